@@ -0,0 +1,362 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/garyburd/redigo/redis"
+)
+
+// analyze         host:port
+//                  --samples <arg>
+//                  --top <arg>
+//                  --count <arg>
+//                  --delimiter <arg>
+//                  --depth <arg>
+var analyzeCommand = cli.Command{
+	Name:        "analyze",
+	Usage:       "report the big keys and slots of redis cluster.",
+	ArgsUsage:   `host:port`,
+	Description: `The analyze command samples the keys of every master and reports the largest keys, the busiest slots and the memory used by key prefix.`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "samples",
+			Value: AnalyzeDefaultSamples,
+			Usage: `Keys sampled on every master, 0 to analyze all the keys.`,
+		},
+		cli.IntFlag{
+			Name:  "top",
+			Value: AnalyzeDefaultTop,
+			Usage: `Number of keys, slots and prefixes shown in every ranking.`,
+		},
+		cli.IntFlag{
+			Name:  "count",
+			Value: ScanDefaultCount,
+			Usage: `COUNT hint given to every SCAN call.`,
+		},
+		cli.StringFlag{
+			Name:  "delimiter",
+			Value: ":",
+			Usage: `Delimiter between the parts of the key names for the prefix breakdown.`,
+		},
+		cli.IntFlag{
+			Name:  "depth",
+			Value: 1,
+			Usage: `Number of key parts making a prefix for the prefix breakdown.`,
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is ""`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "analyze")
+			logrus.Fatalf("Must provide \"host:port\" for analyze command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		rt := NewRedisTrib()
+		if err := rt.AnalyzeClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+const (
+	AnalyzeDefaultSamples = 10000
+	AnalyzeDefaultTop     = 10
+)
+
+// Command returning the number of elements of a key, by type.
+var keyLengthCommands = map[string]string{
+	"string": "STRLEN",
+	"list":   "LLEN",
+	"set":    "SCARD",
+	"zset":   "ZCARD",
+	"hash":   "HLEN",
+	"stream": "XLEN",
+}
+
+// Size and number of elements of a sampled key.
+type KeyStats struct {
+	Key      string
+	Slot     int
+	Node     *ClusterNode
+	Type     string
+	Size     int64
+	Elements int64
+}
+
+// The keys sampled on a master, and the exact number of keys of its
+// slots. Scale extrapolates the sample to all the keys of the master.
+type MasterAnalysis struct {
+	Node   *ClusterNode
+	Keys   []*KeyStats
+	Counts map[int]int
+	Dbsize int
+	Scale  float64
+}
+
+// Average size of the sampled keys of every slot, and of all of them.
+func (ma *MasterAnalysis) slotSizes() (map[int]int64, int64) {
+	sums := make(map[int]int64)
+	nums := make(map[int]int64)
+	var total int64
+	for _, key := range ma.Keys {
+		sums[key.Slot] += key.Size
+		nums[key.Slot]++
+		total += key.Size
+	}
+
+	avgs := make(map[int]int64, len(sums))
+	for slot, sum := range sums {
+		avgs[slot] = sum / nums[slot]
+	}
+	if len(ma.Keys) == 0 {
+		return avgs, 0
+	}
+	return avgs, total / int64(len(ma.Keys))
+}
+
+func (rt *RedisTrib) AnalyzeClusterCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for analyze command")
+	}
+
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return err
+	}
+
+	opts := &ScanOpts{
+		Count:   context.Int("count"),
+		Limit:   context.Int("samples"),
+		Details: true,
+	}
+	masters := rt.masters()
+	analyses := make([]*MasterAnalysis, len(masters))
+	errs := make([]error, len(masters))
+
+	logrus.Printf(">>> Sampling keys of %d masters", len(masters))
+	var wg sync.WaitGroup
+	for i, node := range masters {
+		wg.Add(1)
+		go func(i int, node *ClusterNode) {
+			defer wg.Done()
+			analyses[i], errs[i] = node.Analyze(opts)
+		}(i, node)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("analyze of %s failed: %s", masters[i].String(), err.Error())
+		}
+	}
+
+	top := context.Int("top")
+	ShowLargestKeys(analyses, top)
+	ShowBusiestSlots(analyses, top)
+	ShowKeysPerSlot(analyses)
+	ShowPrefixMemory(analyses, context.String("delimiter"), context.Int("depth"), top)
+	return nil
+}
+
+// Sample the keys of the node with their size and number of elements,
+// and count the keys of every slot it serves.
+func (cn *ClusterNode) Analyze(opts *ScanOpts) (*MasterAnalysis, error) {
+	ma := &MasterAnalysis{Node: cn, Scale: 1}
+
+	var err error
+	if ma.Dbsize, err = cn.Dbsize(); err != nil {
+		return nil, err
+	}
+	if ma.Counts, err = cn.SlotsKeyCount(); err != nil {
+		return nil, err
+	}
+
+	err = cn.ScanKeys(opts, func(keys []*ScannedKey) error {
+		for _, key := range keys {
+			cn.r.Send("MEMORY", "usage", key.Key)
+			if cmd, ok := keyLengthCommands[key.Type]; ok {
+				cn.r.Send(cmd, key.Key)
+			}
+		}
+		if err := cn.r.Flush(); err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			// Keys deleted in the meantime return nil.
+			size, err := redis.Int64(cn.r.Receive())
+			if err != nil && err != redis.ErrNil {
+				return err
+			}
+			stats := &KeyStats{Key: key.Key, Slot: key.Slot, Node: cn, Type: key.Type, Size: size}
+			if _, ok := keyLengthCommands[key.Type]; ok {
+				// A key replaced by an other type errors with WRONGTYPE.
+				if n, err := redis.Int64(cn.r.Receive()); err == nil {
+					stats.Elements = n
+				}
+			}
+			ma.Keys = append(ma.Keys, stats)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ma.Keys) > 0 && ma.Dbsize > len(ma.Keys) {
+		ma.Scale = float64(ma.Dbsize) / float64(len(ma.Keys))
+	}
+	logrus.Printf("%s (%s...) -> %d keys sampled of %d.", cn.String(), cn.Name()[0:8], len(ma.Keys), ma.Dbsize)
+	return ma, nil
+}
+
+func ShowLargestKeys(analyses []*MasterAnalysis, top int) {
+	var keys []*KeyStats
+	for _, ma := range analyses {
+		keys = append(keys, ma.Keys...)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Size != keys[j].Size {
+			return keys[i].Size > keys[j].Size
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > top {
+		keys = keys[:top]
+	}
+
+	logrus.Printf(">>> Largest sampled keys:")
+	for _, key := range keys {
+		logrus.Printf("\t%-9s %-6s %10d elements | slot %-5d | %s | %s",
+			HumanBytes(key.Size), key.Type, key.Elements, key.Slot, key.Node.String(), key.Key)
+	}
+}
+
+func ShowBusiestSlots(analyses []*MasterAnalysis, top int) {
+	type slotStats struct {
+		slot  int
+		node  *ClusterNode
+		keys  int
+		bytes int64
+	}
+
+	var slots []*slotStats
+	for _, ma := range analyses {
+		// Slots without sampled keys are estimated with the average
+		// key size of the master.
+		avgs, avg := ma.slotSizes()
+		for slot, count := range ma.Counts {
+			size, ok := avgs[slot]
+			if !ok {
+				size = avg
+			}
+			slots = append(slots, &slotStats{slot: slot, node: ma.Node, keys: count, bytes: size * int64(count)})
+		}
+	}
+
+	show := func(title string, value func(s *slotStats) int64) {
+		sort.Slice(slots, func(i, j int) bool {
+			if value(slots[i]) != value(slots[j]) {
+				return value(slots[i]) > value(slots[j])
+			}
+			return slots[i].slot < slots[j].slot
+		})
+		logrus.Printf(">>> %s:", title)
+		for i, s := range slots {
+			if i >= top {
+				break
+			}
+			logrus.Printf("\tslot %-5d | %8d keys | ~%-9s | %s", s.slot, s.keys, HumanBytes(s.bytes), s.node.String())
+		}
+	}
+	show("Slots with the most keys", func(s *slotStats) int64 { return int64(s.keys) })
+	show("Slots with the most bytes (estimated)", func(s *slotStats) int64 { return s.bytes })
+}
+
+func ShowKeysPerSlot(analyses []*MasterAnalysis) {
+	logrus.Printf(">>> Keys per slot distribution:")
+	for _, ma := range analyses {
+		if len(ma.Counts) == 0 {
+			logrus.Printf("\t%s (%s...) -> no slots.", ma.Node.String(), ma.Node.Name()[0:8])
+			continue
+		}
+
+		counts := make([]int, 0, len(ma.Counts))
+		empty := 0
+		total := 0
+		for _, count := range ma.Counts {
+			counts = append(counts, count)
+			total += count
+			if count == 0 {
+				empty++
+			}
+		}
+		sort.Ints(counts)
+		percentile := func(p int) int {
+			return counts[(len(counts)-1)*p/100]
+		}
+
+		logrus.Printf("\t%s (%s...) -> %d slots | %d empty | min %d | p50 %d | p90 %d | p99 %d | max %d | avg %.2f",
+			ma.Node.String(), ma.Node.Name()[0:8], len(counts), empty,
+			counts[0], percentile(50), percentile(90), percentile(99), counts[len(counts)-1],
+			float64(total)/float64(len(counts)))
+	}
+}
+
+func ShowPrefixMemory(analyses []*MasterAnalysis, delimiter string, depth int, top int) {
+	keys := make(map[string]float64)
+	bytes := make(map[string]float64)
+	var total float64
+	for _, ma := range analyses {
+		for _, key := range ma.Keys {
+			prefix := KeyPrefix(key.Key, delimiter, depth)
+			keys[prefix] += ma.Scale
+			bytes[prefix] += float64(key.Size) * ma.Scale
+			total += float64(key.Size) * ma.Scale
+		}
+	}
+
+	prefixes := make([]string, 0, len(bytes))
+	for prefix := range bytes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if bytes[prefixes[i]] != bytes[prefixes[j]] {
+			return bytes[prefixes[i]] > bytes[prefixes[j]]
+		}
+		return prefixes[i] < prefixes[j]
+	})
+	if len(prefixes) > top {
+		prefixes = prefixes[:top]
+	}
+
+	logrus.Printf(">>> Memory by key prefix (estimated):")
+	for _, prefix := range prefixes {
+		name := prefix
+		if name == "" {
+			name = "(no prefix)"
+		}
+		// All the sampled keys may be reported with a size of zero.
+		var percent float64
+		if total > 0 {
+			percent = 100 * bytes[prefix] / total
+		}
+		logrus.Printf("\t~%-9s %6.2f%% | ~%d keys | %s",
+			HumanBytes(int64(bytes[prefix])), percent, int64(keys[prefix]), name)
+	}
+}
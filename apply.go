@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+// apply           plan.json [host:port]
+//                  --yes
+//                  --timeout <arg>
+//                  --pipeline <arg>
+var applyCommand = cli.Command{
+	Name:        "apply",
+	Usage:       "apply a saved reshard or rebalance plan.",
+	ArgsUsage:   `plan.json [host:port]`,
+	Description: `The apply command executes a plan saved with --plan-out, if the cluster topology did not change since.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "yes",
+			Usage: `Auto agree to execute the plan.`,
+		},
+		cli.IntFlag{
+			Name:  "timeout",
+			Usage: `Timeout for migrating keys.`,
+		},
+		cli.IntFlag{
+			Name:  "pipeline",
+			Value: MigrateDefaultPipeline,
+			Usage: `Pipeline for migrating keys.`,
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is ""`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 || context.NArg() > 2 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "apply")
+			logrus.Fatalf("Must provide \"plan.json [host:port]\" for apply command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		rt := NewRedisTrib()
+		if err := rt.ApplyClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+func (rt *RedisTrib) ApplyClusterCmd(context *cli.Context) error {
+	var path string
+
+	if path = context.Args().Get(0); path == "" {
+		return errors.New("please check plan.json for apply command")
+	}
+
+	pf, err := ReadPlanFile(path)
+	if err != nil {
+		return err
+	}
+
+	// The plan remembers the cluster it was computed against.
+	addr := context.Args().Get(1)
+	if addr == "" {
+		addr = pf.Cluster
+	}
+
+	logrus.Printf(">>> Applying %s plan created %s to cluster %s", pf.Command, pf.Created, addr)
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return err
+	}
+
+	rt.CheckCluster(true)
+	if len(rt.Errors()) > 0 {
+		logrus.Fatalf("*** Please fix your cluster problem before applying the plan.")
+	}
+
+	if fingerprint := rt.TopologyFingerprint(); fingerprint != pf.Fingerprint {
+		return fmt.Errorf("the cluster topology changed since the plan was computed "+
+			"(fingerprint %s, expected %s), please compute a new plan", fingerprint, pf.Fingerprint)
+	}
+
+	plan, err := rt.PlanFromFile(pf)
+	if err != nil {
+		return err
+	}
+
+	logrus.Printf("  Plan:")
+	plan.Show()
+
+	if !context.Bool("yes") {
+		YesOrDie("Do you want to proceed with the plan?")
+	}
+
+	if context.Int("timeout") > 0 {
+		rt.SetTimeout(context.Int("timeout"))
+	}
+	opts := &MoveOpts{
+		Dots:     true,
+		Update:   true,
+		Pipeline: context.Int("pipeline"),
+	}
+	if err := rt.ExecutePlan(plan, opts); err != nil {
+		return err
+	}
+
+	logrus.Printf("[OK] %d slots moved.", plan.NumSlots())
+	return nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/garyburd/redigo/redis"
+)
+
+// backup          host:port
+//                  --aof
+//                  --replicas
+//                  --timeout <arg>
+//                  --interval <arg>
+//                  --hook <arg>
+var backupCommand = cli.Command{
+	Name:        "backup",
+	Usage:       "save the data of every shard of redis cluster.",
+	ArgsUsage:   `host:port`,
+	Description: `The backup command runs BGSAVE or BGREWRITEAOF on every master, or on one replica of every master, and waits for all of them to finish.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "aof",
+			Usage: `Rewrite the append only file with BGREWRITEAOF instead of BGSAVE.`,
+		},
+		cli.BoolFlag{
+			Name:  "replicas",
+			Usage: `Save on one replica of every master when there is one, to spare the masters.`,
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: BackupDefaultTimeout,
+			Usage: `Maximum time to wait for all the nodes to finish.`,
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Value: BackupDefaultInterval,
+			Usage: `Time between two checks of INFO persistence.`,
+		},
+		cli.StringFlag{
+			Name:  "hook",
+			Usage: `Shell command run for every saved node, with the dump path as $1 and REDIS_TRIB_NODE, REDIS_TRIB_NODE_ID, REDIS_TRIB_DUMP_PATH in the environment.`,
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is "".`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "backup")
+			logrus.Fatalf("Must provide \"host:port\" for backup command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		rt := NewRedisTrib()
+		if err := rt.BackupClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+const (
+	BackupDefaultTimeout  = time.Hour
+	BackupDefaultInterval = time.Second
+)
+
+// The save of a node, and its outcome. LastSave is the time of the
+// save reported by INFO, which has none for AOF rewrites, Finished the
+// time the end was seen.
+type NodeBackup struct {
+	Node     *ClusterNode
+	Master   *ClusterNode
+	Start    time.Time
+	Done     bool
+	Status   string
+	LastSave time.Time
+	Finished time.Time
+	Duration time.Duration
+	Path     string
+	Err      error
+}
+
+func (rt *RedisTrib) BackupClusterCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for backup command")
+	}
+	aof := context.Bool("aof")
+
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return err
+	}
+
+	// Check cluster, only proceed if it looks sane.
+	rt.CheckCluster(false)
+
+	// One node per shard.
+	var backups []*NodeBackup
+	for _, master := range rt.masters() {
+		node := master
+		if context.Bool("replicas") {
+			if replicas := master.ReplicasNodes(); len(replicas) > 0 {
+				node = replicas[0]
+			} else {
+				logrus.Warnf("*** %s has no replica, saving on the master.", master.String())
+			}
+		}
+		backups = append(backups, &NodeBackup{Node: node, Master: master})
+	}
+
+	cmd := "BGSAVE"
+	if aof {
+		cmd = "BGREWRITEAOF"
+	}
+	logrus.Printf(">>> Running %s on %d nodes", cmd, len(backups))
+	for _, b := range backups {
+		if b.Err = b.Node.waitPersistence(aof, context.Duration("interval"), context.Duration("timeout")); b.Err != nil {
+			b.Done = true
+			continue
+		}
+		b.Start = time.Now()
+		if _, err := b.Node.Call(cmd); err != nil {
+			b.Err, b.Done = err, true
+			continue
+		}
+		logrus.Printf("  %s on %s (%s)", cmd, b.Node.String(), b.Node.Name()[0:8])
+	}
+
+	// Poll the nodes until they are all done.
+	deadline := time.Now().Add(context.Duration("timeout"))
+	for {
+		pending := 0
+		for _, b := range backups {
+			if !b.Done {
+				b.poll(aof)
+			}
+			if !b.Done {
+				pending++
+			}
+		}
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			for _, b := range backups {
+				if !b.Done {
+					b.Err, b.Done = errors.New("timeout"), true
+				}
+			}
+			break
+		}
+		time.Sleep(context.Duration("interval"))
+	}
+
+	failed := 0
+	logrus.Printf(">>> Backup report")
+	for _, b := range backups {
+		role := "master"
+		if b.Node != b.Master {
+			role = "replica of " + b.Master.String()
+		}
+		if b.Err != nil {
+			failed++
+			logrus.Errorf("  %s (%s) %s: %s", b.Node.String(), b.Node.Name()[0:8], role, b.Err.Error())
+			continue
+		}
+
+		var err error
+		if b.Path, err = b.Node.dumpPath(aof); err != nil {
+			logrus.Warnf("  *** Dump path of %s unknown: %s", b.Node.String(), err.Error())
+		}
+		when := "saved at " + b.LastSave.Format(time.RFC3339)
+		if b.LastSave.IsZero() {
+			when = "done by " + b.Finished.Format(time.RFC3339)
+		}
+		logrus.Printf("  %s (%s) %s: %s in %s, %s", b.Node.String(), b.Node.Name()[0:8], role,
+			when, b.Duration, b.Path)
+	}
+
+	if hook := context.String("hook"); hook != "" {
+		for _, b := range backups {
+			if b.Err != nil || b.Path == "" {
+				continue
+			}
+			if err := runBackupHook(hook, b); err != nil {
+				failed++
+				logrus.Errorf("  Hook for %s failed: %s", b.Node.String(), err.Error())
+			}
+		}
+	}
+
+	if failed > 0 {
+		logrus.Fatalf("*** %d nodes failed to save.", failed)
+	}
+	logrus.Printf("[OK] All %d nodes saved.", len(backups))
+	return nil
+}
+
+// Wait for a save or rewrite already running on the node, as a second
+// one is refused. BGSAVE is also refused during an AOF rewrite.
+func (cn *ClusterNode) waitPersistence(aof bool, interval, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		info, err := cn.InfoSection("persistence")
+		if err != nil {
+			return err
+		}
+		busy := info["rdb_bgsave_in_progress"] == "1" || info["aof_rewrite_in_progress"] == "1"
+		if aof {
+			busy = info["aof_rewrite_in_progress"] == "1" || info["aof_rewrite_scheduled"] == "1"
+		}
+		if !busy {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timeout waiting for the running save")
+		}
+		logrus.Printf("  Waiting for the save running on %s", cn.String())
+		time.Sleep(interval)
+	}
+}
+
+// Check INFO persistence for the end of the save.
+func (b *NodeBackup) poll(aof bool) {
+	info, err := b.Node.InfoSection("persistence")
+	if err != nil {
+		b.Err, b.Done = err, true
+		return
+	}
+
+	if aof {
+		if info["aof_rewrite_in_progress"] != "0" || info["aof_rewrite_scheduled"] != "0" {
+			return
+		}
+		b.Status = info["aof_last_bgrewrite_status"]
+		b.Duration = infoSeconds(info, "aof_last_rewrite_time_sec")
+	} else {
+		if info["rdb_bgsave_in_progress"] != "0" {
+			return
+		}
+		b.Status = info["rdb_last_bgsave_status"]
+		if last, err := strconv.ParseInt(info["rdb_last_save_time"], 10, 64); err == nil {
+			b.LastSave = time.Unix(last, 0)
+		}
+		b.Duration = infoSeconds(info, "rdb_last_bgsave_time_sec")
+	}
+
+	b.Finished = time.Now()
+	// INFO only counts whole seconds, short saves get the time waited.
+	if b.Duration == 0 {
+		b.Duration = time.Since(b.Start).Round(time.Millisecond)
+	}
+	b.Done = true
+	if b.Status != "ok" {
+		b.Err = fmt.Errorf("last save status %q", b.Status)
+	}
+}
+
+func infoSeconds(info map[string]string, field string) time.Duration {
+	sec, err := strconv.ParseInt(info[field], 10, 64)
+	if err != nil || sec < 0 {
+		return 0
+	}
+	return time.Duration(sec) * time.Second
+}
+
+func (cn *ClusterNode) configGet(name string) (string, error) {
+	conf, err := redis.Strings(cn.Call("CONFIG", "get", name))
+	if err != nil {
+		return "", err
+	}
+	if len(conf) < 2 {
+		return "", fmt.Errorf("no %s config", name)
+	}
+	return conf[1], nil
+}
+
+// Path of the RDB file, or of the append only file or directory, on
+// the host of the node.
+func (cn *ClusterNode) dumpPath(aof bool) (string, error) {
+	dir, err := cn.configGet("dir")
+	if err != nil {
+		return "", err
+	}
+	if aof {
+		// Redis 7 keeps the append only files in a directory.
+		if name, err := cn.configGet("appenddirname"); err == nil {
+			return path.Join(dir, name), nil
+		}
+		name, err := cn.configGet("appendfilename")
+		if err != nil {
+			return "", err
+		}
+		return path.Join(dir, name), nil
+	}
+
+	name, err := cn.configGet("dbfilename")
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, name), nil
+}
+
+func runBackupHook(hook string, b *NodeBackup) error {
+	cmd := exec.Command("sh", "-c", hook, "sh", b.Path)
+	cmd.Env = append(os.Environ(),
+		"REDIS_TRIB_NODE="+b.Node.String(),
+		"REDIS_TRIB_NODE_ID="+b.Node.Name(),
+		"REDIS_TRIB_DUMP_PATH="+b.Path)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		logrus.Printf("  Hook for %s: %s", b.Node.String(), strings.TrimSpace(string(out)))
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// What a command may do to the nodes it runs on.
+type CommandClass int
+
+const (
+	CommandReadOnly CommandClass = iota
+	CommandWrite
+	CommandAdmin
+	CommandDestructive
+)
+
+func (c CommandClass) String() string {
+	switch c {
+	case CommandReadOnly:
+		return "read-only"
+	case CommandWrite:
+		return "write"
+	case CommandAdmin:
+		return "admin"
+	}
+	return "destructive"
+}
+
+// Admin and destructive commands need a confirmation.
+func (c CommandClass) Dangerous() bool {
+	return c >= CommandAdmin
+}
+
+// The class of the commands, by name or by name and subcommand. The
+// commands missing here, like the subcommands not listed, are taken as
+// admin commands.
+var commandClasses = map[string]CommandClass{
+	// Keys and server state, read only.
+	"BITCOUNT": CommandReadOnly, "BITPOS": CommandReadOnly, "DBSIZE": CommandReadOnly,
+	"DUMP": CommandReadOnly, "ECHO": CommandReadOnly, "EXISTS": CommandReadOnly,
+	"GET": CommandReadOnly, "GETBIT": CommandReadOnly, "GETRANGE": CommandReadOnly,
+	"HEXISTS": CommandReadOnly, "HGET": CommandReadOnly, "HGETALL": CommandReadOnly,
+	"HKEYS": CommandReadOnly, "HLEN": CommandReadOnly, "HMGET": CommandReadOnly,
+	"HSCAN": CommandReadOnly, "HSTRLEN": CommandReadOnly, "HVALS": CommandReadOnly,
+	"INFO": CommandReadOnly, "KEYS": CommandReadOnly, "LASTSAVE": CommandReadOnly,
+	"LINDEX": CommandReadOnly, "LLEN": CommandReadOnly, "LRANGE": CommandReadOnly,
+	"MGET": CommandReadOnly, "PFCOUNT": CommandReadOnly, "PING": CommandReadOnly,
+	"PTTL": CommandReadOnly, "RANDOMKEY": CommandReadOnly, "ROLE": CommandReadOnly,
+	"SCAN": CommandReadOnly, "SCARD": CommandReadOnly, "SISMEMBER": CommandReadOnly,
+	"SMEMBERS": CommandReadOnly, "SRANDMEMBER": CommandReadOnly, "SSCAN": CommandReadOnly,
+	"STRLEN": CommandReadOnly, "TIME": CommandReadOnly, "TTL": CommandReadOnly,
+	"TYPE": CommandReadOnly, "XINFO": CommandReadOnly, "XLEN": CommandReadOnly,
+	"XRANGE": CommandReadOnly, "XREVRANGE": CommandReadOnly, "ZCARD": CommandReadOnly,
+	"ZCOUNT": CommandReadOnly, "ZRANGE": CommandReadOnly, "ZRANGEBYSCORE": CommandReadOnly,
+	"ZRANK": CommandReadOnly, "ZREVRANGE": CommandReadOnly, "ZSCAN": CommandReadOnly,
+	"ZSCORE": CommandReadOnly, "COMMAND": CommandReadOnly, "OBJECT": CommandReadOnly,
+	"CONFIG GET": CommandReadOnly, "CLUSTER INFO": CommandReadOnly, "CLUSTER NODES": CommandReadOnly,
+	"CLUSTER SLOTS": CommandReadOnly, "CLUSTER SHARDS": CommandReadOnly, "CLUSTER MYID": CommandReadOnly,
+	"CLUSTER KEYSLOT": CommandReadOnly, "CLUSTER COUNTKEYSINSLOT": CommandReadOnly, "CLUSTER GETKEYSINSLOT": CommandReadOnly,
+	"CLUSTER COUNT-FAILURE-REPORTS": CommandReadOnly, "CLUSTER REPLICAS": CommandReadOnly, "CLUSTER SLAVES": CommandReadOnly,
+	"CLUSTER LINKS": CommandReadOnly, "CLIENT LIST": CommandReadOnly, "CLIENT INFO": CommandReadOnly,
+	"CLIENT ID": CommandReadOnly, "CLIENT GETNAME": CommandReadOnly, "MEMORY USAGE": CommandReadOnly,
+	"MEMORY STATS": CommandReadOnly, "MEMORY DOCTOR": CommandReadOnly, "MEMORY MALLOC-STATS": CommandReadOnly,
+	"LATENCY LATEST": CommandReadOnly, "LATENCY HISTORY": CommandReadOnly, "LATENCY DOCTOR": CommandReadOnly,
+	"SLOWLOG GET": CommandReadOnly, "SLOWLOG LEN": CommandReadOnly, "ACL LIST": CommandReadOnly,
+	"ACL USERS": CommandReadOnly, "ACL WHOAMI": CommandReadOnly, "ACL GETUSER": CommandReadOnly,
+	"ACL CAT": CommandReadOnly, "ACL LOG": CommandReadOnly, "MODULE LIST": CommandReadOnly,
+	"FUNCTION LIST": CommandReadOnly, "FUNCTION DUMP": CommandReadOnly, "FUNCTION STATS": CommandReadOnly,
+	"SCRIPT EXISTS": CommandReadOnly,
+
+	// Keys, written.
+	"APPEND": CommandWrite, "DECR": CommandWrite, "DECRBY": CommandWrite,
+	"DEL": CommandWrite, "EXPIRE": CommandWrite, "EXPIREAT": CommandWrite,
+	"HDEL": CommandWrite, "HINCRBY": CommandWrite, "HINCRBYFLOAT": CommandWrite,
+	"HMSET": CommandWrite, "HSET": CommandWrite, "HSETNX": CommandWrite,
+	"INCR": CommandWrite, "INCRBY": CommandWrite, "INCRBYFLOAT": CommandWrite,
+	"LPOP": CommandWrite, "LPUSH": CommandWrite, "LREM": CommandWrite,
+	"LSET": CommandWrite, "LTRIM": CommandWrite, "MSET": CommandWrite,
+	"PERSIST": CommandWrite, "PEXPIRE": CommandWrite, "PFADD": CommandWrite,
+	"PSETEX": CommandWrite, "RESTORE": CommandWrite, "RPOP": CommandWrite,
+	"RPUSH": CommandWrite, "SADD": CommandWrite, "SET": CommandWrite,
+	"SETEX": CommandWrite, "SETNX": CommandWrite, "SPOP": CommandWrite,
+	"SREM": CommandWrite, "UNLINK": CommandWrite, "XADD": CommandWrite,
+	"XDEL": CommandWrite, "XTRIM": CommandWrite, "ZADD": CommandWrite,
+	"ZINCRBY": CommandWrite, "ZREM": CommandWrite, "ZREMRANGEBYSCORE": CommandWrite,
+
+	// Server configuration and state.
+	"BGREWRITEAOF": CommandAdmin, "BGSAVE": CommandAdmin, "SAVE": CommandAdmin,
+	"ACL SETUSER": CommandAdmin, "ACL DELUSER": CommandAdmin, "ACL LOAD": CommandAdmin,
+	"ACL SAVE": CommandAdmin, "CLIENT KILL": CommandAdmin, "CLIENT PAUSE": CommandAdmin,
+	"CLIENT NO-EVICT": CommandAdmin, "CONFIG SET": CommandAdmin, "CONFIG REWRITE": CommandAdmin,
+	"CONFIG RESETSTAT": CommandAdmin, "CLUSTER MEET": CommandAdmin, "CLUSTER ADDSLOTS": CommandAdmin,
+	"CLUSTER ADDSLOTSRANGE": CommandAdmin, "CLUSTER REPLICATE": CommandAdmin, "CLUSTER SAVECONFIG": CommandAdmin,
+	"CLUSTER BUMPEPOCH": CommandAdmin, "CLUSTER SET-CONFIG-EPOCH": CommandAdmin, "LATENCY RESET": CommandAdmin,
+	"MEMORY PURGE": CommandAdmin, "SLOWLOG RESET": CommandAdmin, "MODULE LOAD": CommandAdmin,
+	"MODULE UNLOAD": CommandAdmin, "SCRIPT KILL": CommandAdmin, "SCRIPT LOAD": CommandAdmin,
+	"FUNCTION LOAD": CommandAdmin, "FUNCTION RESTORE": CommandAdmin, "FUNCTION KILL": CommandAdmin,
+
+	// Data loss or nodes out of service.
+	"FLUSHALL": CommandDestructive, "FLUSHDB": CommandDestructive, "SHUTDOWN": CommandDestructive,
+	"DEBUG": CommandDestructive, "SWAPDB": CommandDestructive, "REPLICAOF": CommandDestructive,
+	"SLAVEOF": CommandDestructive, "MIGRATE": CommandDestructive, "CLUSTER RESET": CommandDestructive,
+	"CLUSTER FORGET": CommandDestructive, "CLUSTER FAILOVER": CommandDestructive, "CLUSTER SETSLOT": CommandDestructive,
+	"CLUSTER DELSLOTS": CommandDestructive, "CLUSTER DELSLOTSRANGE": CommandDestructive, "CLUSTER FLUSHSLOTS": CommandDestructive,
+	"SCRIPT FLUSH": CommandDestructive, "FUNCTION FLUSH": CommandDestructive, "FUNCTION DELETE": CommandDestructive,
+}
+
+// Return the class of a command, looking up its subcommand first.
+func ClassifyCommand(cmd string, args []string) CommandClass {
+	cmd = strings.ToUpper(cmd)
+	if len(args) > 0 {
+		if class, ok := commandClasses[cmd+" "+strings.ToUpper(args[0])]; ok {
+			return class
+		}
+	}
+	if class, ok := commandClasses[cmd]; ok {
+		return class
+	}
+	return CommandAdmin
+}
+
+// A profile of the call command, for a cluster or an environment.
+type CallProfile struct {
+	// Glob-style patterns of the commands refused, like "FLUSH*" or
+	// "CONFIG SET", matched against the command and its subcommand.
+	Deny     []string `json:"deny"`
+	ReadOnly bool     `json:"read_only"`
+}
+
+// Name of the profiles file in the home directory.
+const CallProfilesName = ".redis-trib-profiles.json"
+
+// Read the profile from the profiles file, a JSON object of profiles
+// by name. The file defaults to ~/.redis-trib-profiles.json.
+func LoadCallProfile(path, name string) (*CallProfile, error) {
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, CallProfilesName)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string]*CallProfile)
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("invalid profiles file %s: %s", path, err.Error())
+	}
+	profile, ok := profiles[name]
+	if !ok || profile == nil {
+		return nil, fmt.Errorf("no profile %q in %s", name, path)
+	}
+	return profile, nil
+}
+
+// Return the deny pattern matching the command, "" if it is allowed.
+func (p *CallProfile) Denied(cmd string, args []string) string {
+	names := []string{strings.ToUpper(cmd)}
+	if len(args) > 0 {
+		names = append(names, names[0]+" "+strings.ToUpper(args[0]))
+	}
+	for _, pattern := range p.Deny {
+		for _, name := range names {
+			if StringMatch(strings.ToUpper(pattern), name) {
+				return pattern
+			}
+		}
+	}
+	return ""
+}
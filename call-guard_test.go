@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestClassifyCommand(t *testing.T) {
+	cases := []struct {
+		cmd  string
+		args []string
+		want CommandClass
+	}{
+		{"GET", []string{"foo"}, CommandReadOnly},
+		{"get", []string{"foo"}, CommandReadOnly},
+		{"SET", []string{"foo", "bar"}, CommandWrite},
+		{"CONFIG", []string{"GET", "maxmemory"}, CommandReadOnly},
+		{"config", []string{"get", "maxmemory"}, CommandReadOnly},
+		{"CONFIG", []string{"SET", "maxmemory", "1gb"}, CommandAdmin},
+		{"CONFIG", nil, CommandAdmin},
+		{"CLUSTER", []string{"INFO"}, CommandReadOnly},
+		{"CLUSTER", []string{"RESET", "HARD"}, CommandDestructive},
+		{"CLUSTER", []string{"NOSUCHSUB"}, CommandAdmin},
+		{"FLUSHALL", nil, CommandDestructive},
+		{"FLUSHALL", []string{"ASYNC"}, CommandDestructive},
+		{"DEBUG", []string{"SLEEP", "0"}, CommandDestructive},
+		{"NOSUCHCOMMAND", nil, CommandAdmin},
+		{"", nil, CommandAdmin},
+	}
+	for _, c := range cases {
+		if got := ClassifyCommand(c.cmd, c.args); got != c.want {
+			t.Errorf("ClassifyCommand(%q, %q) = %s, want %s", c.cmd, c.args, got, c.want)
+		}
+	}
+}
+
+func TestCommandClassDangerous(t *testing.T) {
+	cases := []struct {
+		class CommandClass
+		want  bool
+	}{
+		{CommandReadOnly, false},
+		{CommandWrite, false},
+		{CommandAdmin, true},
+		{CommandDestructive, true},
+	}
+	for _, c := range cases {
+		if got := c.class.Dangerous(); got != c.want {
+			t.Errorf("%s.Dangerous() = %v, want %v", c.class, got, c.want)
+		}
+	}
+}
+
+func TestCallProfileDenied(t *testing.T) {
+	profile := &CallProfile{Deny: []string{"FLUSH*", "config set", "CLUSTER RESET", "DEBUG*"}}
+	cases := []struct {
+		cmd  string
+		args []string
+		want string
+	}{
+		{"FLUSHALL", nil, "FLUSH*"},
+		{"flushdb", []string{"ASYNC"}, "FLUSH*"},
+		{"CONFIG", []string{"SET", "maxmemory", "1gb"}, "config set"},
+		{"config", []string{"set"}, "config set"},
+		{"CONFIG", []string{"GET", "maxmemory"}, ""},
+		{"CONFIG", nil, ""},
+		{"CLUSTER", []string{"reset", "soft"}, "CLUSTER RESET"},
+		{"CLUSTER", []string{"INFO"}, ""},
+		{"DEBUG", []string{"SLEEP", "0"}, "DEBUG*"},
+		{"GET", []string{"flushall"}, ""},
+		{"INFO", nil, ""},
+	}
+	for _, c := range cases {
+		if got := profile.Denied(c.cmd, c.args); got != c.want {
+			t.Errorf("Denied(%q, %q) = %q, want %q", c.cmd, c.args, got, c.want)
+		}
+	}
+
+	empty := &CallProfile{}
+	if got := empty.Denied("FLUSHALL", nil); got != "" {
+		t.Errorf("Denied(\"FLUSHALL\") with no deny pattern = %q, want \"\"", got)
+	}
+}
@@ -1,21 +1,77 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
+	"github.com/garyburd/redigo/redis"
 )
 
 // call            host:port command arg arg .. arg
+//                  --masters
+//                  --replicas
+//                  --nodes <arg>
+//                  --workers <arg>
+//                  --output <arg>
+//                  --sum
+//                  --yes
+//                  --read-only
+//                  --profile <arg>
+//                  --profiles <arg>
 var callCommand = cli.Command{
 	Name:        "call",
 	Usage:       "run command in redis cluster.",
 	ArgsUsage:   `host:port command arg arg .. arg`,
 	Description: `The call command for call cmd in every redis cluster node.`,
 	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "masters",
+			Usage: `Only run the command on the masters.`,
+		},
+		cli.BoolFlag{
+			Name:  "replicas",
+			Usage: `Only run the command on the replicas.`,
+		},
+		cli.StringFlag{
+			Name:  "nodes",
+			Usage: `Only run the command on these nodes, given by id, id prefix or host:port, like 3f2a,9b01.`,
+		},
+		cli.IntFlag{
+			Name:  "workers",
+			Value: CallDefaultWorkers,
+			Usage: `Number of nodes the command runs on at once.`,
+		},
+		cli.StringFlag{
+			Name:  "output",
+			Value: CallOutputText,
+			Usage: `Output format of the replies: text or json.`,
+		},
+		cli.BoolFlag{
+			Name:  "sum",
+			Usage: `Also show the sum of the numeric replies, like for DBSIZE.`,
+		},
+		cli.BoolFlag{
+			Name:  "yes",
+			Usage: `Auto agree to run admin and destructive commands.`,
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: `Refuse any command but the read-only ones.`,
+		},
+		cli.StringFlag{
+			Name:  "profile",
+			Usage: `Profile of the profiles file with the commands to refuse, and whether to only allow read-only commands.`,
+		},
+		cli.StringFlag{
+			Name:  "profiles",
+			Usage: `Profiles file, a JSON object like {"prod": {"deny": ["FLUSH*", "CONFIG SET"], "read_only": false}}, ~/` + CallProfilesName + ` by default.`,
+		},
 		cli.StringFlag{
 			Name:  "password, a",
 			Value: "",
@@ -41,6 +97,27 @@ var callCommand = cli.Command{
 	},
 }
 
+const (
+	CallDefaultWorkers = 16
+	CallOutputText     = "text"
+	CallOutputJSON     = "json"
+)
+
+// The reply of a node, as printed with --output json.
+type CallResult struct {
+	Node  string      `json:"node"`
+	ID    string      `json:"id"`
+	Role  string      `json:"role"`
+	Reply interface{} `json:"reply"`
+	Error string      `json:"error,omitempty"`
+}
+
+type CallOutput struct {
+	Command string        `json:"command"`
+	Results []*CallResult `json:"results"`
+	Sum     *float64      `json:"sum,omitempty"`
+}
+
 func (rt *RedisTrib) CallClusterCmd(context *cli.Context) error {
 	var addr string
 
@@ -48,6 +125,14 @@ func (rt *RedisTrib) CallClusterCmd(context *cli.Context) error {
 		return errors.New("please check host:port for call command")
 	}
 
+	output := strings.ToLower(context.String("output"))
+	if output != CallOutputText && output != CallOutputJSON {
+		logrus.Fatalf("Invalid output %q for call: text or json.", output)
+	}
+	if context.Bool("masters") && context.Bool("replicas") {
+		logrus.Fatalf("Options \"--masters\" and \"--replicas\" can't be used together.")
+	}
+
 	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
 		return err
 	}
@@ -55,16 +140,214 @@ func (rt *RedisTrib) CallClusterCmd(context *cli.Context) error {
 	cmd := strings.ToUpper(context.Args().Get(1))
 	cmdArgs := ToInterfaceArray(context.Args()[2:])
 
-	if context.String("password") != "" {
-		RedisPassword = context.String("password")
-	}
-
-	logrus.Printf(">>> Calling %s %s", cmd, cmdArgs)
-	_, err := rt.EachRunCommandAndPrint(cmd, cmdArgs...)
+	nodes, err := rt.selectCallNodes(context.Bool("masters"), context.Bool("replicas"), context.String("nodes"))
 	if err != nil {
-		logrus.Errorf("Command failed: %s", err)
 		return err
 	}
+	if len(nodes) == 0 {
+		logrus.Fatalf("*** No node selected for the call command.")
+	}
+
+	// Guard rails: the profile, the read-only mode, and a confirmation
+	// for the dangerous commands.
+	strArgs := ToStringArray(cmdArgs)
+	class := ClassifyCommand(cmd, strArgs)
+	readOnly := context.Bool("read-only")
+	if name := context.String("profile"); name != "" {
+		profile, err := LoadCallProfile(context.String("profiles"), name)
+		if err != nil {
+			return err
+		}
+		if pattern := profile.Denied(cmd, strArgs); pattern != "" {
+			logrus.Fatalf("*** %s is denied by %q in the %s profile.", cmd, pattern, name)
+		}
+		readOnly = readOnly || profile.ReadOnly
+	}
+	if readOnly && class != CommandReadOnly {
+		logrus.Fatalf("*** %s is a %s command, only read-only commands are allowed.", cmd, class)
+	}
+	if class.Dangerous() && !context.Bool("yes") {
+		logrus.Warnf("*** %s is a %s command, it will run on:", cmd, class)
+		for _, node := range nodes {
+			logrus.Printf("\t%s (%s)", node.String(), node.Name()[0:8])
+		}
+		YesOrDie(fmt.Sprintf("Run %s on these %d nodes?", cmd, len(nodes)))
+	}
+
+	if output == CallOutputText {
+		logrus.Printf(">>> Calling %s %s on %d nodes", cmd, cmdArgs, len(nodes))
+	}
+	ies := RunCommandOnNodes(nodes, context.Int("workers"), cmd, cmdArgs...)
+
+	failed := 0
+	results := make([]*CallResult, len(nodes))
+	for i, node := range nodes {
+		role := "master"
+		if node.HasFlag("slave") {
+			role = "replica"
+		}
+		results[i] = &CallResult{Node: node.String(), ID: node.Name(), Role: role, Reply: ReplyToJSON(ies[i].result)}
+		if ies[i].err != nil {
+			results[i].Error = ies[i].err.Error()
+			failed++
+		}
+	}
+
+	var sum *float64
+	numeric := 0
+	if context.Bool("sum") {
+		var total float64
+		for _, ie := range ies {
+			if n, ok := ReplyNumber(ie.result, ie.err); ok {
+				total += n
+				numeric++
+			}
+		}
+		if math.IsInf(total, 0) {
+			logrus.Warnf("*** The sum of the replies overflows.")
+		} else {
+			sum = &total
+		}
+	}
 
+	if output == CallOutputJSON {
+		data, err := json.MarshalIndent(&CallOutput{
+			Command: strings.Join(append([]string{cmd}, strArgs...), " "),
+			Results: results,
+			Sum:     sum,
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		for i, node := range nodes {
+			val := strings.Trim(FormatReply(ies[i].result, ies[i].err), " \n")
+			logrus.Printf("%s (%s) %s:\n%s", node.String(), node.Name()[0:8], results[i].Role, val)
+		}
+		if sum != nil {
+			logrus.Printf(">>> Sum of %d numeric replies: %s", numeric, strconv.FormatFloat(*sum, 'f', -1, 64))
+			if numeric < len(nodes) {
+				logrus.Warnf("*** %d replies are not numbers.", len(nodes)-numeric)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%s failed on %d of %d nodes", cmd, failed, len(nodes))
+	}
 	return nil
 }
+
+// Return the nodes to run the command on: all of them, the masters or
+// the replicas, among the listed ones if any.
+func (rt *RedisTrib) selectCallNodes(masters, replicas bool, list string) ([]*ClusterNode, error) {
+	nodes := rt.Nodes()
+	if list != "" {
+		nodes = nil
+		for _, item := range strings.Split(list, ",") {
+			item = strings.TrimSpace(item)
+			node := rt.GetNodeByName(item)
+			if node == nil && len(item) < 40 {
+				node = rt.GetNodeByAbbreviatedName(item)
+			}
+			if node == nil {
+				for _, n := range rt.Nodes() {
+					if n.String() == item {
+						node = n
+					}
+				}
+			}
+			if node == nil {
+				return nil, fmt.Errorf("no node or more than one node named %q", item)
+			}
+			nodes = append(nodes, node)
+		}
+	}
+
+	var selected []*ClusterNode
+	for _, node := range nodes {
+		if (masters && node.HasFlag("slave")) || (replicas && !node.HasFlag("slave")) {
+			continue
+		}
+		selected = append(selected, node)
+	}
+	return selected, nil
+}
+
+// Render a reply the way redis-cli does, with the nested replies
+// numbered and indented.
+func FormatReply(reply interface{}, err error) string {
+	if err != nil {
+		return "(error) " + err.Error()
+	}
+	return formatReply(reply, "")
+}
+
+func formatReply(reply interface{}, indent string) string {
+	switch r := reply.(type) {
+	case nil:
+		return "(nil)"
+	case int64:
+		return fmt.Sprintf("(integer) %d", r)
+	case string:
+		return r
+	case []byte:
+		return string(r)
+	case redis.Error:
+		return "(error) " + r.Error()
+	case []interface{}:
+		if len(r) == 0 {
+			return "(empty array)"
+		}
+		width := len(strconv.Itoa(len(r)))
+		lines := make([]string, 0, len(r))
+		for i, elem := range r {
+			prefix := fmt.Sprintf("%*d) ", width, i+1)
+			nested := indent + strings.Repeat(" ", len(prefix))
+			line := prefix + formatReply(elem, nested)
+			if i > 0 {
+				line = indent + line
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n")
+	}
+	return fmt.Sprintf("%v", reply)
+}
+
+// Convert a reply to a value encoding to JSON: strings, numbers, null,
+// arrays, and {"error": ...} for the errors nested in arrays.
+func ReplyToJSON(reply interface{}) interface{} {
+	switch r := reply.(type) {
+	case []byte:
+		return string(r)
+	case redis.Error:
+		return map[string]string{"error": r.Error()}
+	case []interface{}:
+		values := make([]interface{}, len(r))
+		for i, elem := range r {
+			values[i] = ReplyToJSON(elem)
+		}
+		return values
+	}
+	return reply
+}
+
+// Return the number held by an integer reply, or a string reply like
+// the ones of INCRBYFLOAT or GET. Infinities and NaN are not numbers
+// to sum.
+func ReplyNumber(reply interface{}, err error) (float64, bool) {
+	if err != nil {
+		return 0, false
+	}
+	switch r := reply.(type) {
+	case int64:
+		return float64(r), true
+	case string, []byte:
+		s, _ := redis.String(r, nil)
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return n, err == nil && !math.IsInf(n, 0) && !math.IsNaN(n)
+	}
+	return 0, false
+}
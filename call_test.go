@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestFormatReply(t *testing.T) {
+	ten := make([]interface{}, 10)
+	for i := range ten {
+		ten[i] = int64(i)
+	}
+
+	cases := []struct {
+		reply interface{}
+		err   error
+		want  string
+	}{
+		{nil, nil, "(nil)"},
+		{int64(42), nil, "(integer) 42"},
+		{"OK", nil, "OK"},
+		{[]byte("bar"), nil, "bar"},
+		{redis.Error("ERR unknown command"), nil, "(error) ERR unknown command"},
+		{nil, errors.New("connection refused"), "(error) connection refused"},
+		{[]interface{}{}, nil, "(empty array)"},
+		{[]interface{}{[]byte("a"), nil, int64(1)}, nil, "1) a\n2) (nil)\n3) (integer) 1"},
+		{[]interface{}{
+			[]byte("a"),
+			[]interface{}{[]byte("b"), nil},
+			redis.Error("MOVED 3999 127.0.0.1:6381"),
+			[]interface{}{},
+		}, nil, "1) a\n2) 1) b\n   2) (nil)\n3) (error) MOVED 3999 127.0.0.1:6381\n4) (empty array)"},
+		{[]interface{}{[]interface{}{[]interface{}{[]byte("x"), []byte("y")}}}, nil,
+			"1) 1) 1) x\n      2) y"},
+		{ten, nil, " 1) (integer) 0\n 2) (integer) 1\n 3) (integer) 2\n 4) (integer) 3\n 5) (integer) 4\n" +
+			" 6) (integer) 5\n 7) (integer) 6\n 8) (integer) 7\n 9) (integer) 8\n10) (integer) 9"},
+	}
+	for _, c := range cases {
+		if got := FormatReply(c.reply, c.err); got != c.want {
+			t.Errorf("FormatReply(%#v, %v) = %q, want %q", c.reply, c.err, got, c.want)
+		}
+	}
+}
+
+func TestReplyNumber(t *testing.T) {
+	cases := []struct {
+		reply interface{}
+		err   error
+		want  float64
+		ok    bool
+	}{
+		{int64(12), nil, 12, true},
+		{int64(-3), nil, -3, true},
+		{[]byte("3.5"), nil, 3.5, true},
+		{"10", nil, 10, true},
+		{[]byte(" 7 "), nil, 7, true},
+		{[]byte("foo"), nil, 0, false},
+		{[]byte("inf"), nil, 0, false},
+		{[]byte("-inf"), nil, 0, false},
+		{[]byte("nan"), nil, 0, false},
+		{nil, nil, 0, false},
+		{[]interface{}{int64(1)}, nil, 0, false},
+		{redis.Error("ERR wrong type"), nil, 0, false},
+		{int64(5), errors.New("timeout"), 0, false},
+	}
+	for _, c := range cases {
+		got, ok := ReplyNumber(c.reply, c.err)
+		if ok != c.ok || (ok && got != c.want) {
+			t.Errorf("ReplyNumber(%#v, %v) = %v, %v, want %v, %v", c.reply, c.err, got, ok, c.want, c.ok)
+		}
+	}
+}
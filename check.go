@@ -3,18 +3,68 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 )
 
 // check            host:port
+//                  --lag-warning <arg>
+//                  --lag-critical <arg>
+//                  --monitoring
+//                  --uncovered-slots <arg>
+//                  --open-slots <arg>
+//                  --failed-nodes <arg>
+//                  --missing-replicas <arg>
+//                  --min-replicas <arg>
 var checkCommand = cli.Command{
 	Name:        "check",
 	Usage:       "check the redis cluster.",
 	ArgsUsage:   `host:port`,
 	Description: `The check command check for redis cluster.`,
 	Flags: []cli.Flag{
+		cli.Int64Flag{
+			Name:  "lag-warning",
+			Value: HealthDefaultLagWarning,
+			Usage: `Replication lag in bytes for a replica to be reported as warning.`,
+		},
+		cli.Int64Flag{
+			Name:  "lag-critical",
+			Value: HealthDefaultLagCritical,
+			Usage: `Replication lag in bytes for a replica to be reported as critical.`,
+		},
+		cli.BoolFlag{
+			Name: "monitoring",
+			Usage: `Nagios/Icinga plugin mode: print a one-line summary with perfdata and
+	exit with 0 (OK), 1 (WARNING), 2 (CRITICAL) or 3 (UNKNOWN).`,
+		},
+		cli.StringFlag{
+			Name:  "uncovered-slots",
+			Value: "critical",
+			Usage: `Level for slots not covered by any node (critical, warning or ignore).`,
+		},
+		cli.StringFlag{
+			Name:  "open-slots",
+			Value: "warning",
+			Usage: `Level for slots in migrating or importing state (critical, warning or ignore).`,
+		},
+		cli.StringFlag{
+			Name:  "failed-nodes",
+			Value: "warning",
+			Usage: `Level for failed or unreachable nodes (critical, warning or ignore).`,
+		},
+		cli.StringFlag{
+			Name:  "missing-replicas",
+			Value: "warning",
+			Usage: `Level for masters with less than --min-replicas replicas (critical, warning or ignore).`,
+		},
+		cli.IntFlag{
+			Name:  "min-replicas",
+			Value: 1,
+			Usage: `Number of replicas every master serving slots is expected to have.`,
+		},
 		cli.StringFlag{
 			Name:  "password, a",
 			Value: "",
@@ -33,6 +83,9 @@ var checkCommand = cli.Command{
 		}
 
 		rt := NewRedisTrib()
+		if context.Bool("monitoring") {
+			return rt.MonitorClusterCmd(context)
+		}
 		if err := rt.CheckClusterCmd(context); err != nil {
 			return err
 		}
@@ -52,5 +105,129 @@ func (rt *RedisTrib) CheckClusterCmd(context *cli.Context) error {
 	}
 
 	rt.CheckCluster(false)
+	rt.CheckNodesHealth(context.Int64("lag-warning"), context.Int64("lag-critical"))
+	if len(rt.Errors()) > 0 {
+		return fmt.Errorf("cluster check found %d error(s)", len(rt.Errors()))
+	}
 	return nil
 }
+
+// Parse the level of a monitoring rule.
+func parseMonitorLevel(name, level string) (HealthStatus, error) {
+	switch strings.ToLower(level) {
+	case "critical", "crit":
+		return HealthCritical, nil
+	case "warning", "warn":
+		return HealthWarning, nil
+	case "ignore", "ok":
+		return HealthOK, nil
+	}
+	return HealthUnknown, fmt.Errorf("invalid level %q for --%s", level, name)
+}
+
+// Run the check as a Nagios/Icinga plugin: the output is a single line
+// "REDIS CLUSTER <STATUS> - <summary> | <perfdata>" and the exit code is
+// the status.
+func (rt *RedisTrib) MonitorClusterCmd(context *cli.Context) error {
+	unknown := func(format string, args ...interface{}) error {
+		fmt.Printf("REDIS CLUSTER UNKNOWN - %s\n", fmt.Sprintf(format, args...))
+		return cli.NewExitError("", int(HealthUnknown))
+	}
+
+	addr := context.Args().Get(0)
+	levels := make(map[string]HealthStatus)
+	for _, name := range []string{"uncovered-slots", "open-slots", "failed-nodes", "missing-replicas"} {
+		level, err := parseMonitorLevel(name, context.String(name))
+		if err != nil {
+			return unknown("%s", err.Error())
+		}
+		levels[name] = level
+	}
+
+	// Only the summary line goes to the monitoring system, unless
+	// the logs were explicitly sent to a file.
+	if context.GlobalString("log") == "" {
+		logrus.SetOutput(ioutil.Discard)
+	}
+
+	// Loading the cluster aborts on connection errors, which is not
+	// what a plugin should do.
+	seed := NewClusterNode(addr)
+	if err := seed.Connect(false); err != nil {
+		return unknown("can't connect to %s: %s", addr, err.Error())
+	}
+	if !seed.AssertCluster() {
+		return unknown("%s is not configured as a cluster node", addr)
+	}
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return unknown("%s", err.Error())
+	}
+
+	rt.CheckCluster(true)
+
+	status := HealthOK
+	var problems []string
+	rule := func(name string, count int, format string) {
+		if count == 0 || levels[name] == HealthOK {
+			return
+		}
+		status = status.Worse(levels[name])
+		problems = append(problems, fmt.Sprintf(format, count))
+	}
+
+	covered := len(rt.CoveredSlots())
+	rule("uncovered-slots", ClusterHashSlots-covered, "%d slots not covered")
+	rule("open-slots", len(rt.OpenSlots()), "%d open slots")
+	rule("failed-nodes", len(rt.FailedNodes()), "%d failed nodes")
+
+	keys := 0
+	masters := 0
+	missing := 0
+	for _, node := range rt.Nodes() {
+		if !node.HasFlag("master") {
+			continue
+		}
+		masters += 1
+		if dbsize, err := node.Dbsize(); err == nil {
+			keys += dbsize
+		}
+		if len(node.Slots()) > 0 && len(node.ReplicasNodes()) < context.Int("min-replicas") {
+			missing += 1
+		}
+	}
+	rule("missing-replicas", missing, "%d masters without enough replicas")
+
+	if !rt.isConfigConsistent() {
+		status = status.Worse(HealthWarning)
+		problems = append(problems, "nodes don't agree about configuration")
+	}
+
+	// The problems of the nodes caused by an ignored check are ignored
+	// as well, like the fail state of every node when slots are not
+	// covered.
+	for _, nh := range rt.CollectNodesHealth(context.Int64("lag-warning"), context.Int64("lag-critical")) {
+		for _, p := range nh.Problems {
+			if level, ok := levels[p.Cause]; ok && level == HealthOK {
+				continue
+			}
+			status = status.Worse(p.Status)
+			problems = append(problems, fmt.Sprintf("%s %s", nh.Node.String(), p))
+		}
+	}
+
+	summary := fmt.Sprintf("%d masters, %d nodes up, all %d slots covered",
+		masters, len(rt.Nodes()), ClusterHashSlots)
+	if len(problems) > 0 {
+		summary = strings.Join(problems, ", ")
+	}
+
+	totalNodes := len(rt.Nodes()) + len(rt.FailedNodes())
+	fmt.Printf("REDIS CLUSTER %s - %s | keys=%d slots_covered=%d;;;0;%d open_slots=%d nodes_up=%d;;;0;%d nodes_failed=%d masters=%d\n",
+		status, summary, keys, covered, ClusterHashSlots, len(rt.OpenSlots()),
+		len(rt.Nodes()), totalNodes, len(rt.FailedNodes()), masters)
+
+	if status == HealthOK {
+		return nil
+	}
+	return cli.NewExitError("", int(status))
+}
@@ -20,6 +20,13 @@ const (
 	AssignedHashSlot
 )
 
+// Default timeouts of the connections to the nodes, a zero
+// RedisTimeout disables the read and write timeouts.
+var (
+	RedisConnectTimeout = 60 * time.Second
+	RedisTimeout        time.Duration
+)
+
 // detail info for redis node.
 type NodeInfo struct {
 	host       string
@@ -33,7 +40,7 @@ type NodeInfo struct {
 	pingSent   int
 	pingRecv   int
 	weight     int
-	balance    int
+	balance    int64
 	linkStatus string
 	slots      map[int]int
 	migrating  map[int]string
@@ -42,13 +49,25 @@ type NodeInfo struct {
 
 func (ni *NodeInfo) HasFlag(flag string) bool {
 	for _, f := range ni.flags {
-		if strings.Contains(f, flag) {
+		if f == flag {
 			return true
 		}
 	}
 	return false
 }
 
+func (ni *NodeInfo) Name() string {
+	return ni.name
+}
+
+func (ni *NodeInfo) Flags() string {
+	return strings.Join(ni.flags, ",")
+}
+
+func (ni *NodeInfo) Slots() map[int]int {
+	return ni.slots
+}
+
 func (ni *NodeInfo) String() string {
 	return fmt.Sprintf("%s:%d", ni.host, ni.port)
 }
@@ -62,6 +81,9 @@ type ClusterNode struct {
 	friends       []*NodeInfo
 	replicasNodes []*ClusterNode
 	verbose       bool
+
+	connectTimeout time.Duration
+	timeout        time.Duration
 }
 
 func NewClusterNode(addr string) (node *ClusterNode) {
@@ -100,8 +122,10 @@ func NewClusterNode(addr string) (node *ClusterNode) {
 			importing: make(map[int]string),
 			replicate: "",
 		},
-		dirty:   false,
-		verbose: false,
+		dirty:          false,
+		verbose:        false,
+		connectTimeout: RedisConnectTimeout,
+		timeout:        RedisTimeout,
 	}
 
 	if os.Getenv("ENV_MODE_VERBOSE") != "" {
@@ -125,7 +149,7 @@ func (cn *ClusterNode) Name() string {
 
 func (cn *ClusterNode) HasFlag(flag string) bool {
 	for _, f := range cn.info.flags {
-		if strings.Contains(f, flag) {
+		if f == flag {
 			return true
 		}
 	}
@@ -149,11 +173,11 @@ func (cn *ClusterNode) SetWeight(w int) {
 	cn.info.weight = w
 }
 
-func (cn *ClusterNode) Balance() int {
+func (cn *ClusterNode) Balance() int64 {
 	return cn.info.balance
 }
 
-func (cn *ClusterNode) SetBalance(balance int) {
+func (cn *ClusterNode) SetBalance(balance int64) {
 	cn.info.balance = balance
 }
 
@@ -215,12 +239,15 @@ func (cn *ClusterNode) Connect(abort bool) (err error) {
 		addr = fmt.Sprintf("%s:%d", cn.info.host, cn.info.port)
 	}
 	//client, err := redis.DialTimeout("tcp", addr, 0, 1*time.Second, 1*time.Second)
-	var client redis.Conn
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(cn.connectTimeout),
+		redis.DialReadTimeout(cn.timeout),
+		redis.DialWriteTimeout(cn.timeout),
+	}
 	if cn.info.password != "" {
-		client, err = redis.Dial("tcp", addr, redis.DialConnectTimeout(60*time.Second), redis.DialPassword(cn.info.password))
-	} else {
-		client, err = redis.Dial("tcp", addr, redis.DialConnectTimeout(60*time.Second))
+		options = append(options, redis.DialPassword(cn.info.password))
 	}
+	client, err := redis.Dial("tcp", addr, options...)
 	if err != nil {
 		if abort {
 			logrus.Fatalf("Sorry, connect to node %s failed in abort mode!", addr)
@@ -247,6 +274,34 @@ func (cn *ClusterNode) Connect(abort bool) (err error) {
 	return nil
 }
 
+// Set the timeouts used by the next Connect, a zero timeout disables
+// the read and write timeouts.
+func (cn *ClusterNode) SetTimeout(connect, timeout time.Duration) {
+	cn.connectTimeout = connect
+	cn.timeout = timeout
+}
+
+func (cn *ClusterNode) Close() {
+	if cn.r != nil {
+		cn.r.Close()
+		cn.r = nil
+	}
+}
+
+// Open a new connection to a replica in read only mode, as replicas
+// only serve the keys of the slots of their master this way.
+func NewReadOnlyNode(addr string) (*ClusterNode, error) {
+	node := NewClusterNode(addr)
+	if err := node.Connect(false); err != nil {
+		return nil, err
+	}
+	if _, err := node.R().Do("READONLY"); err != nil {
+		node.Close()
+		return nil, err
+	}
+	return node, nil
+}
+
 func (cn *ClusterNode) Call(cmd string, args ...interface{}) (interface{}, error) {
 	err := cn.Connect(true)
 	if err != nil {
@@ -260,6 +315,37 @@ func (cn *ClusterNode) Dbsize() (int, error) {
 	return redis.Int(cn.Call("DBSIZE"))
 }
 
+// Parse the "field:value" lines returned by INFO and CLUSTER INFO.
+func parseInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if kv := strings.SplitN(line, ":", 2); len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	return fields
+}
+
+func (cn *ClusterNode) ClusterInfo() (map[string]string, error) {
+	info, err := redis.String(cn.Call("CLUSTER", "INFO"))
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(info), nil
+}
+
+func (cn *ClusterNode) InfoSection(section string) (map[string]string, error) {
+	info, err := redis.String(cn.Call("INFO", section))
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(info), nil
+}
+
 func (cn *ClusterNode) ClusterAddNode(addr string) (ret string, err error) {
 	host, port, err := net.SplitHostPort(addr)
 	if err != nil || host == "" || port == "" {
@@ -288,12 +374,87 @@ func (cn *ClusterNode) ClusterCountKeysInSlot(slot int) (int, error) {
 	return redis.Int(cn.Call("CLUSTER", "countkeysinslot", slot))
 }
 
-func (cn *ClusterNode) ClusterGetKeysInSlot(slot int, pipeline int) (string, error) {
-	return redis.String(cn.Call("CLUSTER", "getkeysinslot", slot, pipeline))
+func (cn *ClusterNode) ClusterGetKeysInSlot(slot int, pipeline int) ([]string, error) {
+	return redis.Strings(cn.Call("CLUSTER", "getkeysinslot", slot, pipeline))
+}
+
+// Return the number of keys in every slot served by the node, the
+// COUNTKEYSINSLOT calls are pipelined.
+func (cn *ClusterNode) SlotsKeyCount() (map[int]int, error) {
+	slots := make([]int, 0, len(cn.Slots()))
+	for slot := range cn.Slots() {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return cn.CountKeysInSlots(slots)
+}
+
+// Return the number of keys of the node in every slot, also for the
+// slots of their master on replicas.
+func (cn *ClusterNode) CountKeysInSlots(slots []int) (map[int]int, error) {
+	if err := cn.Connect(false); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int]int, len(slots))
+	for len(slots) > 0 {
+		batch := slots
+		if len(batch) > 1000 {
+			batch = slots[:1000]
+		}
+		slots = slots[len(batch):]
+
+		for _, slot := range batch {
+			cn.r.Send("CLUSTER", "countkeysinslot", slot)
+		}
+		if err := cn.r.Flush(); err != nil {
+			return nil, err
+		}
+		for _, slot := range batch {
+			count, err := redis.Int(cn.r.Receive())
+			if err != nil {
+				return nil, err
+			}
+			counts[slot] = count
+		}
+	}
+	return counts, nil
+}
+
+// Estimate the average size in bytes of the keys of a slot, using
+// MEMORY USAGE on at most 'samples' of them.
+func (cn *ClusterNode) SampleSlotMemory(slot int, samples int) (int64, error) {
+	keys, err := cn.ClusterGetKeysInSlot(slot, samples)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		cn.r.Send("MEMORY", "usage", key)
+	}
+	if err := cn.r.Flush(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for range keys {
+		// Keys deleted in the meantime return nil.
+		size, err := redis.Int64(cn.r.Receive())
+		if err != nil && err != redis.ErrNil {
+			return 0, err
+		}
+		total += size
+	}
+	return total / int64(len(keys)), nil
 }
 
-func (cn *ClusterNode) ClusterSetSlot(slot int, cmd string) (string, error) {
-	return redis.String(cn.Call("CLUSTER", "setslot", slot, cmd, cn.Name()))
+// Run CLUSTER SETSLOT slot importing|migrating|node <nodeid>, or
+// CLUSTER SETSLOT slot stable when nodeid is empty.
+func (cn *ClusterNode) ClusterSetSlot(slot int, cmd string, nodeid string) (string, error) {
+	if nodeid == "" {
+		return redis.String(cn.Call("CLUSTER", "setslot", slot, cmd))
+	}
+	return redis.String(cn.Call("CLUSTER", "setslot", slot, cmd, nodeid))
 }
 
 func (cn *ClusterNode) AssertCluster() bool {
@@ -318,47 +479,90 @@ func (cn *ClusterNode) AssertEmpty() bool {
 	return true
 }
 
-func (cn *ClusterNode) LoadInfo(getfriends bool) (err error) {
-	var result string
-	if result, err = redis.String(cn.Call("CLUSTER", "NODES")); err != nil {
-		return err
+// Parse one line of CLUSTER NODES output:
+// name addr flags role ping_sent ping_recv config_epoch link_status slots
+func parseNodeInfo(line string) *NodeInfo {
+	parts := strings.Split(strings.TrimSpace(line), " ")
+	if len(parts) < 8 {
+		return nil
 	}
 
-	nodes := strings.Split(result, "\n")
-	for _, val := range nodes {
-		// name addr flags role ping_sent ping_recv link_status slots
-		parts := strings.Split(val, " ")
-		if len(parts) <= 3 {
-			continue
-		}
+	sent, _ := strconv.ParseInt(parts[4], 0, 32)
+	recv, _ := strconv.ParseInt(parts[5], 0, 32)
+	addr := strings.Split(parts[1], "@")[0]
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.ParseUint(port, 10, 0)
+
+	node := &NodeInfo{
+		name:       parts[0],
+		addr:       parts[1],
+		flags:      strings.Split(parts[2], ","),
+		replicate:  parts[3],
+		pingSent:   int(sent),
+		pingRecv:   int(recv),
+		linkStatus: parts[7],
+
+		host:      host,
+		port:      uint(p),
+		slots:     make(map[int]int),
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+	}
 
-		sent, _ := strconv.ParseInt(parts[4], 0, 32)
-		recv, _ := strconv.ParseInt(parts[5], 0, 32)
-		addr := strings.Split(parts[1], "@")[0]
-		host, port, _ := net.SplitHostPort(addr)
-		p, _ := strconv.ParseUint(port, 10, 0)
-
-		node := &NodeInfo{
-			name:       parts[0],
-			addr:       parts[1],
-			flags:      strings.Split(parts[2], ","),
-			replicate:  parts[3],
-			pingSent:   int(sent),
-			pingRecv:   int(recv),
-			linkStatus: parts[6],
+	if parts[3] == "-" {
+		node.replicate = ""
+	}
 
-			host:      host,
-			port:      uint(p),
-			slots:     make(map[int]int),
-			migrating: make(map[int]string),
-			importing: make(map[int]string),
+	for i := 8; i < len(parts); i++ {
+		slots := strings.Trim(parts[i], "[]")
+		if strings.Contains(slots, "-<-") {
+			slotStr := strings.Split(slots, "-<-")
+			slotId, _ := strconv.Atoi(slotStr[0])
+			node.importing[slotId] = slotStr[1]
+		} else if strings.Contains(slots, "->-") {
+			slotStr := strings.Split(slots, "->-")
+			slotId, _ := strconv.Atoi(slotStr[0])
+			node.migrating[slotId] = slotStr[1]
+		} else if strings.Contains(slots, "-") {
+			slotStr := strings.Split(slots, "-")
+			firstId, _ := strconv.Atoi(slotStr[0])
+			lastId, _ := strconv.Atoi(slotStr[1])
+			for slot := firstId; slot <= lastId; slot++ {
+				node.slots[slot] = NewHashSlot
+			}
+		} else if slotId, err := strconv.Atoi(slots); err == nil {
+			node.slots[slotId] = NewHashSlot
 		}
+	}
+
+	return node
+}
+
+// Return the cluster view of the node, one entry for every line
+// of CLUSTER NODES.
+func (cn *ClusterNode) ClusterNodes() ([]*NodeInfo, error) {
+	result, err := redis.String(cn.Call("CLUSTER", "NODES"))
+	if err != nil {
+		return nil, err
+	}
 
-		if parts[3] == "-" {
-			node.replicate = ""
+	var nodes []*NodeInfo
+	for _, val := range strings.Split(result, "\n") {
+		if node := parseNodeInfo(val); node != nil {
+			nodes = append(nodes, node)
 		}
+	}
+	return nodes, nil
+}
 
-		if strings.Contains(parts[2], "myself") {
+func (cn *ClusterNode) LoadInfo(getfriends bool) (err error) {
+	var nodes []*NodeInfo
+	if nodes, err = cn.ClusterNodes(); err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if node.HasFlag("myself") {
 			if cn.info != nil {
 				cn.info.name = node.name
 				cn.info.addr = node.addr
@@ -371,25 +575,14 @@ func (cn *ClusterNode) LoadInfo(getfriends bool) (err error) {
 				cn.info = node
 			}
 
-			for i := 8; i < len(parts); i++ {
-				slots := parts[i]
-				if strings.Contains(slots, "<") {
-					slotStr := strings.Split(slots, "-<-")
-					slotId, _ := strconv.Atoi(slotStr[0])
-					cn.info.importing[slotId] = slotStr[1]
-				} else if strings.Contains(slots, ">") {
-					slotStr := strings.Split(slots, "->-")
-					slotId, _ := strconv.Atoi(slotStr[0])
-					cn.info.migrating[slotId] = slotStr[1]
-				} else if strings.Contains(slots, "-") {
-					slotStr := strings.Split(slots, "-")
-					firstId, _ := strconv.Atoi(slotStr[0])
-					lastId, _ := strconv.Atoi(slotStr[1])
-					cn.AddSlots(firstId, lastId)
-				} else {
-					firstId, _ := strconv.Atoi(slots)
-					cn.AddSlots(firstId, firstId)
-				}
+			for slot := range node.slots {
+				cn.AddSlots(slot, slot)
+			}
+			for slot, name := range node.importing {
+				cn.info.importing[slot] = name
+			}
+			for slot, name := range node.migrating {
+				cn.info.migrating[slot] = name
 			}
 		} else if getfriends {
 			cn.friends = append(cn.friends, node)
@@ -522,23 +715,7 @@ func (cn *ClusterNode) GetConfigSignature() string {
 	return strings.Join(config, "|")
 }
 
-///////////////////////////////////////////////////////////
-// some useful struct contains cluster node.
-type ClusterArray []ClusterNode
-
-func (c ClusterArray) Len() int {
-	return len(c)
-}
-
-func (c ClusterArray) Swap(i, j int) {
-	c[i], c[j] = c[j], c[i]
-}
-
-func (c ClusterArray) Less(i, j int) bool {
-	return len(c[i].Slots()) < len(c[j].Slots())
-}
-
 type MovedNode struct {
-	Source ClusterNode
+	Source *ClusterNode
 	Slot   int
 }
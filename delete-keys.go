@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/garyburd/redigo/redis"
+)
+
+// delete-keys     host:port
+//                  --match <arg>
+//                  --type <arg>
+//                  --min-ttl <arg>
+//                  --no-ttl
+//                  --count <arg>
+//                  --confirm <arg>
+//                  --sample <arg>
+//                  --batch <arg>
+//                  --rate <arg>
+//                  --deleted-log <arg>
+var deleteKeysCommand = cli.Command{
+	Name:        "delete-keys",
+	Usage:       "delete the keys matching a pattern in redis cluster.",
+	ArgsUsage:   `host:port`,
+	Description: `The delete-keys command shows the number of keys matching a pattern, and deletes them when --confirm is given the same number.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "match",
+			Value: "",
+			Usage: `Glob-style pattern of the keys to delete, like 'session:*'.`,
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Value: "",
+			Usage: `Only delete keys of this type: string, list, set, zset, hash or stream.`,
+		},
+		cli.Int64Flag{
+			Name:  "min-ttl",
+			Usage: `Only delete keys expiring in at least this many seconds, or never expiring.`,
+		},
+		cli.BoolFlag{
+			Name:  "no-ttl",
+			Usage: `Only delete keys without an expire.`,
+		},
+		cli.IntFlag{
+			Name:  "count",
+			Value: ScanDefaultCount,
+			Usage: `COUNT hint given to every SCAN call.`,
+		},
+		cli.Int64Flag{
+			Name:  "confirm",
+			Usage: `Number of keys expected to be deleted, as shown by the dry-run.`,
+		},
+		cli.IntFlag{
+			Name:  "sample",
+			Value: DeleteDefaultSample,
+			Usage: `Number of matching keys shown in the dry-run.`,
+		},
+		cli.IntFlag{
+			Name:  "batch",
+			Value: DeleteDefaultBatch,
+			Usage: `Number of keys of the same slot deleted by every UNLINK.`,
+		},
+		cli.IntFlag{
+			Name:  "rate",
+			Value: DeleteDefaultRate,
+			Usage: `Maximum number of keys deleted per second, 0 for no limit.`,
+		},
+		cli.StringFlag{
+			Name:  "deleted-log",
+			Value: "",
+			Usage: `File receiving the names of the deleted keys, the default is delete-keys-<time>.log.`,
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is ""`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "delete-keys")
+			logrus.Fatalf("Must provide \"host:port\" for delete-keys command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		rt := NewRedisTrib()
+		if err := rt.DeleteKeysClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+const (
+	DeleteDefaultSample = 10
+	DeleteDefaultBatch  = 100
+	DeleteDefaultRate   = 1000
+)
+
+func (rt *RedisTrib) DeleteKeysClusterCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for delete-keys command")
+	}
+
+	opts, err := ScanOptsFromContext(context)
+	if err != nil {
+		return err
+	}
+	if opts.Match == "" {
+		logrus.Fatalf("Option \"--match\" is required for delete-keys command!")
+	}
+
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return err
+	}
+
+	rt.CheckCluster(true)
+	if len(rt.Errors()) > 0 {
+		logrus.Fatalf("*** Please fix your cluster problem before deleting keys.")
+	}
+
+	// Keys grouped by node and slot, since a multi-key UNLINK must only
+	// name keys of the same slot.
+	logrus.Printf(">>> Scanning keys matching %q on %d masters", opts.Match, len(rt.masters()))
+	keys := make(map[*ClusterNode]map[int][]string)
+	var sample []string
+	var total int64
+	err = rt.ScanMasters(opts, func(key *ScannedKey) error {
+		if keys[key.Node] == nil {
+			keys[key.Node] = make(map[int][]string)
+		}
+		keys[key.Node][key.Slot] = append(keys[key.Node][key.Slot], key.Key)
+		if len(sample) < context.Int("sample") {
+			sample = append(sample, key.Key)
+		}
+		total++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.Printf("*** %d keys match.", total)
+	for _, key := range sample {
+		logrus.Printf("\t%s", key)
+	}
+	if total == 0 {
+		return nil
+	}
+
+	if !context.IsSet("confirm") {
+		logrus.Printf("*** Dry-run, nothing deleted. Run again with --confirm %d to delete them.", total)
+		return nil
+	}
+	if expected := context.Int64("confirm"); expected != total {
+		return fmt.Errorf("%d keys match but --confirm expected %d, nothing deleted", total, expected)
+	}
+
+	path := context.String("deleted-log")
+	if path == "" {
+		path = fmt.Sprintf("delete-keys-%s.log", time.Now().Format("20060102-150405"))
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	deletedLog := bufio.NewWriter(file)
+	defer deletedLog.Flush()
+
+	logrus.Printf(">>> Deleting %d keys, names logged to %s", total, path)
+	d := &keysDeleter{
+		batch: context.Int("batch"),
+		rate:  context.Int("rate"),
+		start: time.Now(),
+		log:   deletedLog,
+	}
+	if d.batch <= 0 {
+		d.batch = DeleteDefaultBatch
+	}
+
+	for _, node := range rt.masters() {
+		slots := make([]int, 0, len(keys[node]))
+		for slot := range keys[node] {
+			slots = append(slots, slot)
+		}
+		sort.Ints(slots)
+
+		for _, slot := range slots {
+			if err := d.deleteKeys(node, keys[node][slot]); err != nil {
+				deletedLog.Flush()
+				return fmt.Errorf("delete keys of slot %d on %s failed: %s", slot, node.String(), err.Error())
+			}
+		}
+	}
+
+	logrus.Printf("[OK] %d keys deleted, %d already gone, in %s.",
+		d.deleted, d.sent-d.deleted, time.Since(d.start).Round(time.Millisecond))
+	return nil
+}
+
+// Delete keys in batches, keeping under 'rate' keys per second.
+type keysDeleter struct {
+	batch   int
+	rate    int
+	start   time.Time
+	sent    int64
+	deleted int64
+	useDel  bool
+	log     *bufio.Writer
+}
+
+func (d *keysDeleter) deleteKeys(node *ClusterNode, keys []string) error {
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > d.batch {
+			batch = keys[:d.batch]
+		}
+		keys = keys[len(batch):]
+
+		// UNLINK frees the memory in background, fall back to DEL
+		// with servers older than 4.0.
+		cmd := "UNLINK"
+		if d.useDel {
+			cmd = "DEL"
+		}
+		n, err := redis.Int64(node.Call(cmd, ToInterfaceArray(batch)...))
+		if err != nil && !d.useDel && strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+			d.useDel = true
+			n, err = redis.Int64(node.Call("DEL", ToInterfaceArray(batch)...))
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, key := range batch {
+			fmt.Fprintln(d.log, key)
+		}
+		d.sent += int64(len(batch))
+		d.deleted += n
+
+		if d.rate > 0 {
+			expected := time.Duration(d.sent) * time.Second / time.Duration(d.rate)
+			if elapsed := time.Since(d.start); elapsed < expected {
+				time.Sleep(expected - elapsed)
+			}
+		}
+	}
+	return nil
+}
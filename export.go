@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/garyburd/redigo/redis"
+)
+
+// export          host:port
+//                  --format <arg>
+//                  --out <arg>
+//                  --split <arg>
+//                  --from-replicas
+//                  --count <arg>
+var exportCommand = cli.Command{
+	Name:        "export",
+	Usage:       "export the keys of redis cluster to files.",
+	ArgsUsage:   `host:port`,
+	Description: `The export command dumps the keys of every master to RDB or JSON lines files, with a manifest the import command can reload into another cluster.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Value: ExportFormatRdb,
+			Usage: `Format of the files: rdb, or jsonl with one JSON object per key.`,
+		},
+		cli.StringFlag{
+			Name:  "out, o",
+			Usage: `Directory to write the files and the manifest to.`,
+		},
+		cli.StringFlag{
+			Name:  "split",
+			Value: ExportSplitNode,
+			Usage: `Write a file per master with node, or per slot with slot.`,
+		},
+		cli.BoolFlag{
+			Name:  "from-replicas",
+			Usage: `Read the keys from a replica of every master when there is one.`,
+		},
+		cli.IntFlag{
+			Name:  "count",
+			Value: ScanDefaultCount,
+			Usage: `COUNT hint given to every SCAN call, and keys dumped at once.`,
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is "".`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "export")
+			logrus.Fatalf("Must provide \"host:port\" for export command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		rt := NewRedisTrib()
+		if err := rt.ExportClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+const (
+	ExportFormatRdb       = "rdb"
+	ExportFormatJsonl     = "jsonl"
+	ExportSplitNode       = "node"
+	ExportSplitSlot       = "slot"
+	ExportManifestName    = "manifest.json"
+	ExportManifestVersion = 1
+)
+
+type ExportOpts struct {
+	Dir          string
+	Format       string
+	Split        string
+	FromReplicas bool
+	Count        int
+}
+
+// The manifest of an export: the topology of the cluster, the files
+// written and the number of keys of every slot.
+type ExportManifest struct {
+	Version     int                   `json:"version"`
+	Cluster     string                `json:"cluster"`
+	Created     string                `json:"created"`
+	Fingerprint string                `json:"fingerprint"`
+	Format      string                `json:"format"`
+	Split       string                `json:"split"`
+	Keys        int64                 `json:"keys"`
+	Nodes       []*ExportManifestNode `json:"nodes"`
+	Files       []*ExportManifestFile `json:"files"`
+	SlotKeys    map[int]int64         `json:"slot_keys"`
+}
+
+type ExportManifestNode struct {
+	ID           string   `json:"id"`
+	Addr         string   `json:"addr"`
+	Slots        string   `json:"slots"`
+	Replicas     []string `json:"replicas"`
+	ExportedFrom string   `json:"exported_from"`
+}
+
+type ExportManifestFile struct {
+	Name  string `json:"name"`
+	Node  string `json:"node"`
+	Slots string `json:"slots"`
+	Keys  int64  `json:"keys"`
+}
+
+// A key of a JSON lines export. The value is decoded for reading, the
+// DUMP payload is what gets restored.
+type ExportRecord struct {
+	Key      string      `json:"key"`
+	Slot     int         `json:"slot"`
+	Type     string      `json:"type"`
+	ExpireAt int64       `json:"expire_at,omitempty"` // unix time in milliseconds
+	Value    interface{} `json:"value,omitempty"`
+	Dump     []byte      `json:"dump"`
+}
+
+func (rt *RedisTrib) ExportClusterCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for export command")
+	}
+
+	opts := &ExportOpts{
+		Dir:          context.String("out"),
+		Format:       context.String("format"),
+		Split:        context.String("split"),
+		FromReplicas: context.Bool("from-replicas"),
+		Count:        context.Int("count"),
+	}
+	if opts.Dir == "" {
+		logrus.Fatalf("Option \"--out\" is required for export command!")
+	}
+	if opts.Format != ExportFormatRdb && opts.Format != ExportFormatJsonl {
+		logrus.Fatalf("Invalid format %q for export: rdb or jsonl.", opts.Format)
+	}
+	if opts.Split != ExportSplitNode && opts.Split != ExportSplitSlot {
+		logrus.Fatalf("Invalid split %q for export: node or slot.", opts.Split)
+	}
+
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return err
+	}
+
+	// Check cluster, only proceed if it looks sane.
+	rt.CheckCluster(false)
+	if len(rt.OpenSlots()) > 0 {
+		logrus.Fatalf("*** Please fix the open slots with the fix command before exporting.")
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return err
+	}
+
+	m := &ExportManifest{
+		Version:     ExportManifestVersion,
+		Cluster:     addr,
+		Created:     time.Now().UTC().Format(time.RFC3339),
+		Fingerprint: rt.TopologyFingerprint(),
+		Format:      opts.Format,
+		Split:       opts.Split,
+		SlotKeys:    make(map[int]int64),
+	}
+
+	start := time.Now()
+	masters := rt.masters()
+	results := make([]*exportResult, len(masters))
+	errs := make([]error, len(masters))
+	logrus.Printf(">>> Exporting the keys of %d masters to %s", len(masters), opts.Dir)
+
+	var wg sync.WaitGroup
+	for i, master := range masters {
+		wg.Add(1)
+		go func(i int, master *ClusterNode) {
+			defer wg.Done()
+			results[i], errs[i] = rt.ExportMaster(master, opts)
+		}(i, master)
+	}
+	wg.Wait()
+
+	for i, master := range masters {
+		if errs[i] != nil {
+			return fmt.Errorf("export of %s failed: %s", master.String(), errs[i].Error())
+		}
+
+		var replicas []string
+		for _, r := range master.ReplicasNodes() {
+			replicas = append(replicas, r.String())
+		}
+		m.Nodes = append(m.Nodes, &ExportManifestNode{
+			ID:           master.Name(),
+			Addr:         master.String(),
+			Slots:        MergeNumArray2NumRange(sortedSlots(master.Slots())),
+			Replicas:     replicas,
+			ExportedFrom: results[i].from,
+		})
+		m.Files = append(m.Files, results[i].files...)
+		for slot, n := range results[i].slots {
+			m.SlotKeys[slot] = n
+			m.Keys += n
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(opts.Dir, ExportManifestName)
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	logrus.Printf("[OK] %d keys exported to %d files in %s", m.Keys, len(m.Files), time.Since(start).Round(time.Millisecond))
+	logrus.Printf(">>> Manifest saved to %s, reload it with: redis-trib import --manifest %s host:port", path, path)
+	return nil
+}
+
+// What the export of a master wrote.
+type exportResult struct {
+	from  string
+	files []*ExportManifestFile
+	slots map[int]int64
+}
+
+// Open a connection to the node to export the keys of the master from:
+// one of its replicas if asked and possible, or the master itself.
+func exportSource(master *ClusterNode, opts *ExportOpts) (*ClusterNode, error) {
+	if opts.FromReplicas {
+		for _, r := range master.ReplicasNodes() {
+			if node, err := NewReadOnlyNode(r.String()); err == nil {
+				return node, nil
+			}
+		}
+		logrus.Warnf("*** No replica of %s reachable, exporting from the master.", master.String())
+	}
+
+	node := NewClusterNode(master.String())
+	if err := node.Connect(false); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// Export the keys of a master, scanning them for a file per node, or
+// slot by slot for a file per slot.
+func (rt *RedisTrib) ExportMaster(master *ClusterNode, opts *ExportOpts) (*exportResult, error) {
+	node, err := exportSource(master, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer node.Close()
+
+	result := &exportResult{from: node.String(), slots: make(map[int]int64)}
+	if opts.Split == ExportSplitNode {
+		name := fmt.Sprintf("%s.%s", master.Name(), opts.Format)
+		w := newExportWriter(filepath.Join(opts.Dir, name), opts.Format)
+		err := node.ScanKeys(&ScanOpts{Count: opts.Count}, func(keys []*ScannedKey) error {
+			names := make([]string, 0, len(keys))
+			for _, key := range keys {
+				names = append(names, key.Key)
+			}
+			return exportKeys(node, names, w, result.slots)
+		})
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if w.Keys() > 0 {
+			result.files = append(result.files, &ExportManifestFile{
+				Name:  name,
+				Node:  master.Name(),
+				Slots: MergeNumArray2NumRange(sortedSlots(master.Slots())),
+				Keys:  w.Keys(),
+			})
+		}
+		return result, nil
+	}
+
+	for _, slot := range sortedSlots(master.Slots()) {
+		count, err := node.ClusterCountKeysInSlot(slot)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			continue
+		}
+		names, err := node.ClusterGetKeysInSlot(slot, count)
+		if err != nil {
+			return nil, err
+		}
+
+		name := fmt.Sprintf("slot-%05d.%s", slot, opts.Format)
+		w := newExportWriter(filepath.Join(opts.Dir, name), opts.Format)
+		for len(names) > 0 && err == nil {
+			batch := names
+			if len(batch) > opts.Count {
+				batch = names[:opts.Count]
+			}
+			names = names[len(batch):]
+			err = exportKeys(node, batch, w, result.slots)
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if w.Keys() > 0 {
+			result.files = append(result.files, &ExportManifestFile{
+				Name:  name,
+				Node:  master.Name(),
+				Slots: fmt.Sprintf("%d", slot),
+				Keys:  w.Keys(),
+			})
+		}
+	}
+	return result, nil
+}
+
+// Dump the keys and write them, counting them by slot.
+func exportKeys(node *ClusterNode, names []string, w exportWriter, slots map[int]int64) error {
+	entries, err := node.DumpKeys(names)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		slot := int(Key2Slot(e.Key))
+		if err := w.Write(e, slot); err != nil {
+			return err
+		}
+		slots[slot]++
+	}
+	return nil
+}
+
+// Dump the keys with their expire time, the DUMP and PTTL calls are
+// pipelined. Keys deleted since they were listed are left out.
+func (cn *ClusterNode) DumpKeys(names []string) ([]*RdbEntry, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	for _, name := range names {
+		cn.r.Send("DUMP", name)
+		cn.r.Send("PTTL", name)
+	}
+	if err := cn.r.Flush(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	entries := make([]*RdbEntry, 0, len(names))
+	var failed error
+	for _, name := range names {
+		payload, err := redis.Bytes(cn.r.Receive())
+		if err != nil && err != redis.ErrNil && failed == nil {
+			failed = err
+		}
+		ttl, terr := redis.Int64(cn.r.Receive())
+		if terr != nil && failed == nil {
+			failed = terr
+		}
+		if err != nil || terr != nil || ttl == -2 {
+			continue
+		}
+
+		e, err := ParseDumpPayload(name, payload)
+		if err != nil {
+			if failed == nil {
+				failed = fmt.Errorf("key %q: %s", name, err.Error())
+			}
+			continue
+		}
+		if ttl > 0 {
+			e.ExpireAt = now + ttl
+		}
+		entries = append(entries, e)
+	}
+	if failed != nil {
+		return nil, failed
+	}
+	return entries, nil
+}
+
+// A file of an export, created with the first key written to it.
+type exportWriter interface {
+	Write(e *RdbEntry, slot int) error
+	Keys() int64
+	Close() error
+}
+
+func newExportWriter(path, format string) exportWriter {
+	if format == ExportFormatJsonl {
+		return &jsonlExportWriter{path: path}
+	}
+	return &rdbExportWriter{path: path}
+}
+
+type rdbExportWriter struct {
+	path string
+	file *os.File
+	wr   *RdbWriter
+	keys int64
+}
+
+// The file gets the RDB version of the first key, the versions of a
+// master's keys are all the same.
+func (w *rdbExportWriter) Write(e *RdbEntry, slot int) error {
+	if w.file == nil {
+		file, err := os.Create(w.path)
+		if err != nil {
+			return err
+		}
+		w.file = file
+		if w.wr, err = NewRdbWriter(file, int(e.Version)); err != nil {
+			return err
+		}
+	}
+	w.keys++
+	return w.wr.Write(e)
+}
+
+func (w *rdbExportWriter) Keys() int64 {
+	return w.keys
+}
+
+func (w *rdbExportWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	if w.wr != nil {
+		if err := w.wr.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	return w.file.Close()
+}
+
+type jsonlExportWriter struct {
+	path string
+	file *os.File
+	w    *bufio.Writer
+	enc  *json.Encoder
+	keys int64
+}
+
+func (w *jsonlExportWriter) Write(e *RdbEntry, slot int) error {
+	if w.file == nil {
+		file, err := os.Create(w.path)
+		if err != nil {
+			return err
+		}
+		w.file = file
+		w.w = bufio.NewWriterSize(file, 1<<16)
+		w.enc = json.NewEncoder(w.w)
+	}
+
+	record := &ExportRecord{
+		Key:      e.Key,
+		Slot:     slot,
+		Type:     e.TypeName(),
+		ExpireAt: e.ExpireAt,
+		Dump:     e.Payload(),
+	}
+	// Module values can't be decoded, they are only restored.
+	if value, err := e.Value(); err == nil {
+		record.Value = value
+	}
+	w.keys++
+	return w.enc.Encode(record)
+}
+
+func (w *jsonlExportWriter) Keys() int64 {
+	return w.keys
+}
+
+func (w *jsonlExportWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	if err := w.w.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Read the manifest of an export, given its path or its directory.
+// Also return the directory of the files.
+func ReadExportManifest(path string) (*ExportManifest, string, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, ExportManifestName)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m := &ExportManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, "", fmt.Errorf("invalid manifest %s: %s", path, err.Error())
+	}
+	if m.Version != ExportManifestVersion {
+		return nil, "", fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	if m.Format != ExportFormatRdb && m.Format != ExportFormatJsonl {
+		return nil, "", fmt.Errorf("unsupported export format %q", m.Format)
+	}
+	return m, filepath.Dir(path), nil
+}
+
+// Call f with every key of the files of an export, checking that each
+// file has the number of keys listed in the manifest.
+func EachExportEntry(m *ExportManifest, dir string, f func(e *RdbEntry) error) error {
+	for _, mf := range m.Files {
+		path := filepath.Join(dir, mf.Name)
+		var n int64
+		var err error
+		if m.Format == ExportFormatJsonl {
+			n, err = eachJsonlEntry(path, f)
+		} else {
+			n, err = eachRdbEntry(path, f)
+		}
+		if err != nil {
+			return fmt.Errorf("read %s failed: %s", path, err.Error())
+		}
+		if n != mf.Keys {
+			return fmt.Errorf("%s has %d keys, the manifest lists %d", path, n, mf.Keys)
+		}
+	}
+	return nil
+}
+
+func eachRdbEntry(path string, f func(e *RdbEntry) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader, err := NewRdbReader(file)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for {
+		e, err := reader.Next()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		n++
+		if err := f(e); err != nil {
+			return n, err
+		}
+	}
+}
+
+func eachJsonlEntry(path string, f func(e *RdbEntry) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(bufio.NewReaderSize(file, 1<<16))
+	var n int64
+	for {
+		record := &ExportRecord{}
+		if err := dec.Decode(record); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+
+		e, err := ParseDumpPayload(record.Key, record.Dump)
+		if err != nil {
+			return n, fmt.Errorf("key %q: %s", record.Key, err.Error())
+		}
+		e.ExpireAt = record.ExpireAt
+		n++
+		if err := f(e); err != nil {
+			return n, err
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+// Nodes forgotten with CLUSTER FORGET are blacklisted for 60 seconds,
+// after that they are learned again from the gossip of any node that
+// still knows them. All the nodes must forget within this window.
+const ForgetBlacklistTTL = 60 * time.Second
+
+// Timeout used to make sure a failed node is really down before
+// forgetting it.
+const ForgetProbeTimeout = 2 * time.Second
+
+// forget-failed   host:port
+//                  --yes
+var forgetFailedCommand = cli.Command{
+	Name:        "forget-failed",
+	Usage:       "forget failed nodes in redis cluster.",
+	ArgsUsage:   `host:port`,
+	Description: `The forget-failed command sends CLUSTER FORGET about failed or noaddr nodes to every reachable node.`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "yes",
+			Usage: `Auto agree to forget the failed nodes.`,
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is ""`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "forget-failed")
+			logrus.Fatalf("Must provide host:port for forget-failed command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		rt := NewRedisTrib()
+		if err := rt.ForgetFailedClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+func (rt *RedisTrib) ForgetFailedClusterCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for forget-failed command")
+	}
+
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return err
+	}
+
+	// Every reachable node may know about a different set of ghosts,
+	// so collect them from the view of all the loaded nodes.
+	ghosts := make(map[string]*NodeInfo)
+	var names []string
+	for _, node := range rt.Nodes() {
+		view, err := node.ClusterNodes()
+		if err != nil {
+			logrus.Errorf("Load cluster nodes from %s failed: %s", node.String(), err.Error())
+			continue
+		}
+
+		for _, n := range view {
+			if !n.HasFlag("fail") && !n.HasFlag("noaddr") {
+				continue
+			}
+			if rt.GetNodeByName(n.Name()) != nil {
+				continue
+			}
+			if g, ok := ghosts[n.Name()]; ok {
+				// Keep the view claiming the biggest number of slots.
+				if len(n.Slots()) > len(g.Slots()) {
+					ghosts[n.Name()] = n
+				}
+				continue
+			}
+			ghosts[n.Name()] = n
+			names = append(names, n.Name())
+		}
+	}
+
+	var forget []string
+	for _, name := range names {
+		n := ghosts[name]
+		if len(n.Slots()) > 0 {
+			logrus.Errorf("*** Node %s (%s) still owns %d slots, refusing to forget it.",
+				n.String(), name, len(n.Slots()))
+			continue
+		}
+		if !n.HasFlag("noaddr") && isNodeReachable(n) {
+			logrus.Errorf("*** Node %s (%s) is flagged as failed but still reachable, refusing to forget it.",
+				n.String(), name)
+			continue
+		}
+		logrus.Printf("Failed node %s (%s) flags: %s", n.String(), name, n.Flags())
+		forget = append(forget, name)
+	}
+
+	if len(forget) == 0 {
+		logrus.Printf("[OK] No failed nodes to forget.")
+		return nil
+	}
+
+	if !context.Bool("yes") {
+		YesOrDie(fmt.Sprintf("Forget the above %d node(s) in all the cluster?", len(forget)))
+	}
+
+	logrus.Printf(">>> Sending CLUSTER FORGET messages to the cluster...")
+	deadline := time.Now().Add(ForgetBlacklistTTL)
+	refused := make(map[string]bool)
+	for {
+		pending := 0
+		for _, node := range rt.Nodes() {
+			known := rt.knownNodeNames(node)
+			for _, name := range forget {
+				if name == node.Name() || !known[name] || refused[node.Name()+name] {
+					continue
+				}
+
+				pending += 1
+				if _, err := node.ClusterForgetNodeID(name); err != nil {
+					// A replica can't forget its own master, retrying
+					// would not help.
+					if !strings.Contains(err.Error(), "Unknown node") {
+						logrus.Errorf("Forget %s on %s failed: %s", name, node.String(), err.Error())
+						refused[node.Name()+name] = true
+					}
+				}
+			}
+		}
+
+		if pending == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("nodes still know the failed nodes after %s, please retry", ForgetBlacklistTTL)
+		}
+		time.Sleep(time.Millisecond * 500)
+	}
+
+	if len(refused) > 0 {
+		return fmt.Errorf("%d CLUSTER FORGET request(s) refused by the nodes", len(refused))
+	}
+	logrus.Printf("[OK] %d failed node(s) forgotten by all the reachable nodes.", len(forget))
+	return nil
+}
+
+// Return the set of node IDs present in the cluster view of 'node'.
+func (rt *RedisTrib) knownNodeNames(node *ClusterNode) map[string]bool {
+	known := make(map[string]bool)
+
+	view, err := node.ClusterNodes()
+	if err != nil {
+		return known
+	}
+	for _, n := range view {
+		known[n.Name()] = true
+	}
+	return known
+}
+
+// Return true if the node answers to PING on its address.
+func isNodeReachable(n *NodeInfo) bool {
+	probe := NewClusterNode(n.String())
+	probe.SetTimeout(ForgetProbeTimeout, ForgetProbeTimeout)
+	if err := probe.Connect(false); err != nil {
+		return false
+	}
+	probe.Close()
+	return true
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	HealthDefaultLagWarning  = 1024 * 1024
+	HealthDefaultLagCritical = 16 * 1024 * 1024
+)
+
+// The health verdict of a node or of the whole cluster, the values
+// are the Nagios plugin exit codes.
+type HealthStatus int
+
+const (
+	HealthOK HealthStatus = iota
+	HealthWarning
+	HealthCritical
+	HealthUnknown
+)
+
+func (s HealthStatus) String() string {
+	switch s {
+	case HealthOK:
+		return "OK"
+	case HealthWarning:
+		return "WARNING"
+	case HealthCritical:
+		return "CRITICAL"
+	}
+	return "UNKNOWN"
+}
+
+// Return the most severe of the two status. CRITICAL wins over
+// everything, an UNKNOWN node is worse than a WARNING one only when
+// nothing is known to be broken.
+func (s HealthStatus) Worse(other HealthStatus) HealthStatus {
+	rank := map[HealthStatus]int{
+		HealthOK:       0,
+		HealthUnknown:  1,
+		HealthWarning:  2,
+		HealthCritical: 3,
+	}
+	if rank[other] > rank[s] {
+		return other
+	}
+	return s
+}
+
+// Health of a single node, as read from CLUSTER INFO and, for
+// replicas, INFO replication.
+type NodeHealth struct {
+	Node       *ClusterNode
+	State      string
+	SlotsPfail int
+	SlotsFail  int
+	KnownNodes int
+	Size       int
+	LinkStatus string
+	Lag        int64
+	Status     HealthStatus
+	Problems   []*HealthProblem
+}
+
+// A problem of a node. The cause, if known, is the check of the whole
+// cluster explaining it, like "uncovered-slots" or "failed-nodes".
+type HealthProblem struct {
+	Status HealthStatus
+	Cause  string
+	Text   string
+}
+
+func (p *HealthProblem) String() string {
+	return p.Text
+}
+
+func (nh *NodeHealth) problem(status HealthStatus, cause string, format string, args ...interface{}) {
+	nh.Status = nh.Status.Worse(status)
+	nh.Problems = append(nh.Problems, &HealthProblem{Status: status, Cause: cause, Text: fmt.Sprintf(format, args...)})
+}
+
+// Collect the health of every loaded node. Replicas lagging more than
+// lagWarning or lagCritical bytes behind their master are reported.
+func (rt *RedisTrib) CollectNodesHealth(lagWarning, lagCritical int64) []*NodeHealth {
+	var result []*NodeHealth
+	offsets := make(map[string]int64)
+
+	for _, node := range rt.Nodes() {
+		nh := &NodeHealth{Node: node, Status: HealthOK}
+		result = append(result, nh)
+
+		info, err := node.ClusterInfo()
+		if err != nil {
+			nh.problem(HealthUnknown, "", "CLUSTER INFO failed: %s", err.Error())
+			continue
+		}
+		nh.State = info["cluster_state"]
+		nh.SlotsPfail, _ = strconv.Atoi(info["cluster_slots_pfail"])
+		nh.SlotsFail, _ = strconv.Atoi(info["cluster_slots_fail"])
+		nh.KnownNodes, _ = strconv.Atoi(info["cluster_known_nodes"])
+		nh.Size, _ = strconv.Atoi(info["cluster_size"])
+
+		// A node goes to the fail state when some slots are served by
+		// failed nodes or by no node at all.
+		if nh.State != "ok" {
+			cause := "uncovered-slots"
+			if nh.SlotsFail > 0 {
+				cause = "failed-nodes"
+			}
+			nh.problem(HealthCritical, cause, "cluster_state:%s", nh.State)
+		}
+		if nh.SlotsFail > 0 {
+			nh.problem(HealthCritical, "failed-nodes", "%d slots in fail state", nh.SlotsFail)
+		}
+		if nh.SlotsPfail > 0 {
+			nh.problem(HealthWarning, "failed-nodes", "%d slots in pfail state", nh.SlotsPfail)
+		}
+
+		if node.Replicate() == "" {
+			continue
+		}
+
+		repl, err := node.InfoSection("replication")
+		if err != nil {
+			nh.problem(HealthUnknown, "", "INFO replication failed: %s", err.Error())
+			continue
+		}
+		// The master of the replica is missing when it failed.
+		master := rt.GetNodeByName(node.Replicate())
+		nh.LinkStatus = repl["master_link_status"]
+		if nh.LinkStatus != "up" {
+			cause := ""
+			if master == nil {
+				cause = "failed-nodes"
+			}
+			nh.problem(HealthCritical, cause, "master_link_status:%s", nh.LinkStatus)
+			continue
+		}
+		if master == nil {
+			continue
+		}
+		if _, ok := offsets[master.Name()]; !ok {
+			minfo, err := master.InfoSection("replication")
+			if err != nil {
+				nh.problem(HealthUnknown, "", "INFO replication of master %s failed: %s", master.String(), err.Error())
+				continue
+			}
+			offsets[master.Name()], _ = strconv.ParseInt(minfo["master_repl_offset"], 10, 64)
+		}
+		offset, _ := strconv.ParseInt(repl["slave_repl_offset"], 10, 64)
+		nh.Lag = offsets[master.Name()] - offset
+		if nh.Lag < 0 {
+			nh.Lag = 0
+		}
+
+		if lagCritical > 0 && nh.Lag > lagCritical {
+			nh.problem(HealthCritical, "", "replication lag %d bytes", nh.Lag)
+		} else if lagWarning > 0 && nh.Lag > lagWarning {
+			nh.problem(HealthWarning, "", "replication lag %d bytes", nh.Lag)
+		}
+	}
+
+	return result
+}
+
+// Show the health section of the check command and return the overall
+// verdict for the cluster.
+func (rt *RedisTrib) CheckNodesHealth(lagWarning, lagCritical int64) HealthStatus {
+	logrus.Printf(">>> Check nodes health...")
+	status := HealthOK
+
+	health := rt.CollectNodesHealth(lagWarning, lagCritical)
+	knownNodes := make(map[int]bool)
+	sizes := make(map[int]bool)
+	for _, nh := range health {
+		role := "M"
+		replication := ""
+		if nh.Node.Replicate() != "" {
+			role = "S"
+			replication = fmt.Sprintf(" link:%s lag:%d", nh.LinkStatus, nh.Lag)
+		}
+		logrus.Printf("%s: %s state:%s known_nodes:%d size:%d pfail:%d fail:%d%s -> %s",
+			role, nh.Node.String(), nh.State, nh.KnownNodes, nh.Size,
+			nh.SlotsPfail, nh.SlotsFail, replication, nh.Status)
+		for _, p := range nh.Problems {
+			logrus.Warnf("   %s", p)
+		}
+
+		if nh.State != "" {
+			knownNodes[nh.KnownNodes] = true
+			sizes[nh.Size] = true
+		}
+		status = status.Worse(nh.Status)
+	}
+
+	if len(knownNodes) > 1 {
+		logrus.Warnf("[WARNING] Nodes don't agree about the number of known nodes.")
+		status = status.Worse(HealthWarning)
+	}
+	if len(sizes) > 1 {
+		logrus.Warnf("[WARNING] Nodes don't agree about the cluster size.")
+		status = status.Worse(HealthWarning)
+	}
+	if len(rt.FailedNodes()) > 0 {
+		status = status.Worse(HealthWarning)
+	}
+	if len(rt.Errors()) > 0 {
+		status = status.Worse(HealthCritical)
+	}
+
+	msg := fmt.Sprintf("[%s] Cluster health is %s.", status, strings.ToLower(status.String()))
+	if status == HealthOK {
+		logrus.Printf(msg)
+	} else {
+		logrus.Warnf(msg)
+	}
+	return status
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/garyburd/redigo/redis"
+)
+
+// hotkeys         host:port
+//                  --samples <arg>
+//                  --duration <arg>
+//                  --top <arg>
+//                  --count <arg>
+//                  --threshold <arg>
+//                  --plan-out <arg>
+var hotkeysCommand = cli.Command{
+	Name:        "hotkeys",
+	Usage:       "find the hot keys and slots of redis cluster.",
+	ArgsUsage:   `host:port`,
+	Description: `The hotkeys command samples the access frequency of the keys with OBJECT FREQ when all the masters use an LFU maxmemory-policy, or with MONITOR otherwise, and suggests slots to reshard.`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "samples",
+			Value: AnalyzeDefaultSamples,
+			Usage: `Keys sampled with OBJECT FREQ on every master, 0 for all the keys.`,
+		},
+		cli.DurationFlag{
+			Name:  "duration",
+			Value: HotkeysDefaultDuration,
+			Usage: `Length of the MONITOR window, used unless all the masters use LFU.`,
+		},
+		cli.IntFlag{
+			Name:  "top",
+			Value: AnalyzeDefaultTop,
+			Usage: `Number of keys and slots shown in every ranking.`,
+		},
+		cli.IntFlag{
+			Name:  "count",
+			Value: ScanDefaultCount,
+			Usage: `COUNT hint given to every SCAN call.`,
+		},
+		cli.IntFlag{
+			Name:  "threshold",
+			Value: RebalanceDefaultThreshold,
+			Usage: `Percentage of load over the average above which slots are suggested to move.`,
+		},
+		cli.StringFlag{
+			Name:  "plan-out",
+			Value: "",
+			Usage: `Save the suggested moves to a JSON file to be executed later with apply.`,
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is ""`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "hotkeys")
+			logrus.Fatalf("Must provide \"host:port\" for hotkeys command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		rt := NewRedisTrib()
+		if err := rt.HotkeysClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+const (
+	HotkeysDefaultDuration = 5 * time.Second
+	// Initial LFU counter of a new key, LFU_INIT_VAL in redis.
+	LfuInitVal = 5
+)
+
+// Commands seen by MONITOR whose first argument is not a key.
+var monitorKeylessCommands = map[string]bool{
+	"auth": true, "client": true, "cluster": true, "command": true,
+	"config": true, "dbsize": true, "eval": true, "evalsha": true,
+	"info": true, "memory": true, "monitor": true, "object": true,
+	"ping": true, "psubscribe": true, "publish": true, "readonly": true,
+	"scan": true, "script": true, "select": true, "slowlog": true,
+	"subscribe": true,
+}
+
+// The heat of a key: the number of accesses estimated from its LFU
+// counter, or the number of commands seen on it during the MONITOR
+// window.
+type KeyHeat struct {
+	Key  string
+	Slot int
+	Node *ClusterNode
+	Heat int64
+}
+
+// The heat of the sampled keys of a master. Scale extrapolates the
+// sample to all the keys of the master.
+type MasterHeat struct {
+	Node  *ClusterNode
+	Mode  string
+	Keys  map[string]*KeyHeat
+	Scale float64
+}
+
+func (mh *MasterHeat) add(key string, slot int, heat int64) {
+	if kh, ok := mh.Keys[key]; ok {
+		kh.Heat += heat
+		return
+	}
+	mh.Keys[key] = &KeyHeat{Key: key, Slot: slot, Node: mh.Node, Heat: heat}
+}
+
+func (rt *RedisTrib) HotkeysClusterCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for hotkeys command")
+	}
+
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return err
+	}
+
+	opts := &ScanOpts{
+		Count: context.Int("count"),
+		Limit: context.Int("samples"),
+	}
+	duration := context.Duration("duration")
+	masters := rt.masters()
+	heats := make([]*MasterHeat, len(masters))
+	errs := make([]error, len(masters))
+
+	// The heats of all the masters must be measured the same way to be
+	// compared: OBJECT FREQ only if all of them use LFU.
+	mode := "lfu"
+	for _, node := range masters {
+		lfu, err := node.UsesLFU()
+		if err != nil {
+			return err
+		}
+		if !lfu {
+			mode = "monitor"
+		}
+	}
+
+	logrus.Printf(">>> Sampling keys access of %d masters", len(masters))
+	var wg sync.WaitGroup
+	for i, node := range masters {
+		wg.Add(1)
+		go func(i int, node *ClusterNode) {
+			defer wg.Done()
+			heats[i], errs[i] = node.SampleHeat(mode, opts, duration)
+		}(i, node)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("sample of %s failed: %s", masters[i].String(), err.Error())
+		}
+	}
+
+	top := context.Int("top")
+	ShowHotKeys(heats, top)
+	costs := ShowHotSlots(heats, top)
+	return rt.suggestHotSlotsMoves(context, addr, heats, costs)
+}
+
+// Return true if the node uses an LFU maxmemory-policy, needed by
+// OBJECT FREQ.
+func (cn *ClusterNode) UsesLFU() (bool, error) {
+	conf, err := redis.Strings(cn.Call("CONFIG", "get", "maxmemory-policy"))
+	if err != nil {
+		return false, err
+	}
+	return len(conf) == 2 && strings.Contains(conf[1], "lfu"), nil
+}
+
+// Sample the heat of the keys of the node, with OBJECT FREQ in "lfu"
+// mode, with MONITOR during 'duration' in "monitor" mode.
+func (cn *ClusterNode) SampleHeat(mode string, opts *ScanOpts, duration time.Duration) (*MasterHeat, error) {
+	mh := &MasterHeat{Node: cn, Mode: mode, Keys: make(map[string]*KeyHeat), Scale: 1}
+
+	var err error
+	if mode == "lfu" {
+		err = cn.sampleFreq(mh, opts)
+	} else {
+		err = cn.sampleMonitor(mh, duration)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Printf("%s (%s...) -> %d keys sampled with %s.", cn.String(), cn.Name()[0:8], len(mh.Keys), strings.ToUpper(mh.Mode))
+	return mh, nil
+}
+
+func (cn *ClusterNode) sampleFreq(mh *MasterHeat, opts *ScanOpts) error {
+	dbsize, err := cn.Dbsize()
+	if err != nil {
+		return err
+	}
+	conf, err := redis.Strings(cn.Call("CONFIG", "get", "lfu-log-factor"))
+	if err != nil {
+		return err
+	}
+	factor := int64(10)
+	if len(conf) == 2 {
+		if f, err := strconv.ParseInt(conf[1], 10, 64); err == nil {
+			factor = f
+		}
+	}
+
+	err = cn.ScanKeys(opts, func(keys []*ScannedKey) error {
+		for _, key := range keys {
+			cn.r.Send("OBJECT", "freq", key.Key)
+		}
+		if err := cn.r.Flush(); err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			freq, err := redis.Int64(cn.r.Receive())
+			if err == redis.ErrNil {
+				// Deleted in the meantime.
+				continue
+			} else if err != nil {
+				return err
+			}
+			mh.add(key.Key, key.Slot, LfuHits(freq, factor))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(mh.Keys) > 0 && dbsize > len(mh.Keys) {
+		mh.Scale = float64(dbsize) / float64(len(mh.Keys))
+	}
+	return nil
+}
+
+// Estimate the number of accesses which brought a key to an LFU
+// counter. The counter is logarithmic: from LFU_INIT_VAL on, an access
+// only increments it with probability 1/((counter-LFU_INIT_VAL)*factor+1).
+// The decay of the counter over time is not accounted for.
+func LfuHits(counter int64, factor int64) int64 {
+	n := counter - LfuInitVal
+	if n <= 0 {
+		return 0
+	}
+	return n + factor*n*(n-1)/2
+}
+
+// Count the commands on every key with MONITOR, on a dedicated
+// connection closed at the end of the window.
+func (cn *ClusterNode) sampleMonitor(mh *MasterHeat, duration time.Duration) error {
+	monitor := NewClusterNode(cn.String())
+	if err := monitor.Connect(false); err != nil {
+		return err
+	}
+	defer monitor.Close()
+
+	if _, err := redis.String(monitor.R().Do("MONITOR")); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(duration)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+
+		line, err := redis.String(redis.ReceiveWithTimeout(monitor.R(), remaining))
+		if err != nil {
+			// Nothing received until the end of the window.
+			if time.Now().After(deadline) {
+				return nil
+			}
+			return err
+		}
+
+		args := parseMonitorLine(line)
+		if len(args) < 2 || monitorKeylessCommands[strings.ToLower(args[0])] {
+			continue
+		}
+		// Keys of other slots are not keys, but the arguments of
+		// commands without keys.
+		slot := int(Key2Slot(args[1]))
+		if _, ok := cn.Slots()[slot]; ok {
+			mh.add(args[1], slot, 1)
+		}
+	}
+}
+
+// Return the command and the arguments of a MONITOR line like:
+// 1339518083.107412 [0 127.0.0.1:60866] "set" "key" "value"
+func parseMonitorLine(line string) []string {
+	start := strings.Index(line, "] ")
+	if start < 0 {
+		return nil
+	}
+
+	var args []string
+	var arg []byte
+	quoted := false
+	s := line[start+2:]
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !quoted {
+			if c == '"' {
+				quoted = true
+				arg = arg[:0]
+			}
+			continue
+		}
+
+		switch {
+		case c == '"':
+			quoted = false
+			args = append(args, string(arg))
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				arg = append(arg, '\n')
+			case 'r':
+				arg = append(arg, '\r')
+			case 't':
+				arg = append(arg, '\t')
+			case 'a':
+				arg = append(arg, '\a')
+			case 'b':
+				arg = append(arg, '\b')
+			case 'x':
+				if i+2 < len(s) {
+					if b, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+						arg = append(arg, byte(b))
+						i += 2
+						continue
+					}
+				}
+				arg = append(arg, 'x')
+			default:
+				arg = append(arg, s[i])
+			}
+		default:
+			arg = append(arg, c)
+		}
+	}
+	return args
+}
+
+func ShowHotKeys(heats []*MasterHeat, top int) {
+	var keys []*KeyHeat
+	for _, mh := range heats {
+		for _, key := range mh.Keys {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Heat != keys[j].Heat {
+			return keys[i].Heat > keys[j].Heat
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > top {
+		keys = keys[:top]
+	}
+
+	logrus.Printf(">>> Hottest sampled keys:")
+	for _, key := range keys {
+		logrus.Printf("\t%8d | slot %-5d | %s | %s", key.Heat, key.Slot, key.Node.String(), key.Key)
+	}
+}
+
+// Show the hottest slots and the heat of every master. Return the
+// heat of every slot.
+func ShowHotSlots(heats []*MasterHeat, top int) map[int]int64 {
+	costs := make(map[int]int64)
+	owners := make(map[int]*ClusterNode)
+	var total int64
+	for _, mh := range heats {
+		for _, key := range mh.Keys {
+			heat := int64(float64(key.Heat) * mh.Scale)
+			costs[key.Slot] += heat
+			owners[key.Slot] = mh.Node
+			total += heat
+		}
+	}
+
+	slots := make([]int, 0, len(costs))
+	for slot := range costs {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		if costs[slots[i]] != costs[slots[j]] {
+			return costs[slots[i]] > costs[slots[j]]
+		}
+		return slots[i] < slots[j]
+	})
+
+	logrus.Printf(">>> Hottest slots:")
+	for i, slot := range slots {
+		if i >= top {
+			break
+		}
+		logrus.Printf("\tslot %-5d | %8d | %6.2f%% | %s", slot, costs[slot],
+			100*float64(costs[slot])/float64(total), owners[slot].String())
+	}
+
+	logrus.Printf(">>> Heat by master:")
+	for _, mh := range heats {
+		load := SlotsLoad(mh.Node.Slots(), costs)
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(load) / float64(total)
+		}
+		logrus.Printf("\t%s (%s...) -> %d | %6.2f%% | %d slots | %s",
+			mh.Node.String(), mh.Node.Name()[0:8], load, share, len(mh.Node.Slots()), mh.Mode)
+	}
+	return costs
+}
+
+// Suggest slots to move from the hottest masters to the coldest ones,
+// with the same planner as rebalance using the heat as slot cost.
+func (rt *RedisTrib) suggestHotSlotsMoves(context *cli.Context, addr string, heats []*MasterHeat, costs map[int]int64) error {
+	var nodes []*ClusterNode
+	var total int64
+	for _, mh := range heats {
+		if len(mh.Node.Slots()) == 0 {
+			continue
+		}
+		nodes = append(nodes, mh.Node)
+		total += SlotsLoad(mh.Node.Slots(), costs)
+	}
+	if len(nodes) < 2 || total == 0 {
+		logrus.Printf("*** Not enough load sampled to suggest any move.")
+		return nil
+	}
+
+	expected := total / int64(len(nodes))
+	threshold := int64(context.Int("threshold"))
+	overThreshold := false
+	for _, node := range nodes {
+		load := SlotsLoad(node.Slots(), costs)
+		node.SetBalance(load - expected)
+		if expected > 0 && (load-expected)*100 > expected*threshold {
+			overThreshold = true
+		}
+	}
+	if !overThreshold {
+		logrus.Printf("*** No move needed! All masters are within the %d%% threshold.", threshold)
+		return nil
+	}
+
+	plan := rt.PlanRebalance(nodes, costs)
+	if plan.NumSlots() == 0 {
+		logrus.Printf("*** The load is concentrated in single slots, moving them would not spread it.")
+		return nil
+	}
+
+	logrus.Printf(">>> Suggested moves:")
+	plan.Show()
+	logrus.Printf(">>> Simulated heat distribution:")
+	plan.ShowDistribution(nodes, costs, "HEAT")
+	for _, move := range plan.Moves {
+		logrus.Printf("\tredis-trib reshard --slots-range %s --to %s %s",
+			MergeNumArray2NumRange(move.Slots), move.Target.Name(), addr)
+	}
+
+	if path := context.String("plan-out"); path != "" {
+		return rt.SavePlan(plan, path, "hotkeys", addr)
+	}
+	return nil
+}
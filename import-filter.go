@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// A rule of a rename file: the part of the key matching the regular
+// expression is replaced by the template, where $1 or ${name} are
+// the submatches.
+type RenameRule struct {
+	Matched  int64 // first for atomic alignment on 32-bit platforms
+	Regexp   *regexp.Regexp
+	Template string
+}
+
+// Load the rename rules of a file, one "regexp template" per line.
+// Empty lines and lines starting with # are ignored, and "" stands for
+// an empty template to remove the matching part.
+func LoadRenameRules(path string) ([]*RenameRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []*RenameRule
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"regexp template\", got %q", path, n, line)
+		}
+		re, err := regexp.Compile(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err.Error())
+		}
+		template := fields[1]
+		if template == `""` {
+			template = ""
+		}
+		rules = append(rules, &RenameRule{Regexp: re, Template: template})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Rename the key with the first rule matching it.
+func ApplyRenameRules(rules []*RenameRule, key string) string {
+	for _, rule := range rules {
+		if rule.Regexp.MatchString(key) {
+			atomic.AddInt64(&rule.Matched, 1)
+			return rule.Regexp.ReplaceAllString(key, rule.Template)
+		}
+	}
+	return key
+}
+
+// Keys of the sources to import, counting the keys every filter
+// excluded. The counters are shared by the scanning goroutines.
+type ImportFilter struct {
+	Matched   int64
+	ShortTTL  int64
+	OtherType int64
+	Excluded  []int64 // by exclude pattern
+
+	Match    string
+	Excludes []string
+	MinTTL   int64 // seconds
+	Types    map[string]bool
+	Rules    []*RenameRule
+}
+
+func NewImportFilter(match string, excludes []string, minTTL int64, types []string) *ImportFilter {
+	f := &ImportFilter{
+		Excluded: make([]int64, len(excludes)),
+		Match:    match,
+		Excludes: excludes,
+		MinTTL:   minTTL,
+	}
+	if len(types) > 0 {
+		f.Types = make(map[string]bool)
+		for _, t := range types {
+			f.Types[t] = true
+		}
+	}
+	return f
+}
+
+// The type and TTL of the keys are only needed by some filters.
+func (f *ImportFilter) needDetails() bool {
+	return f.MinTTL > 0 || len(f.Types) > 0
+}
+
+// Whether some keys of the sources may be left out.
+func (f *ImportFilter) Selective() bool {
+	return f.Match != "" || len(f.Excludes) > 0 || f.MinTTL > 0 || len(f.Types) > 0
+}
+
+// Scan the keys of a source with the match pattern, fetching their type
+// and TTL when needed.
+func (f *ImportFilter) scanOpts() *ScanOpts {
+	return &ScanOpts{Match: f.Match, Count: ScanDefaultCount, Details: f.needDetails()}
+}
+
+// Whether to import a key of type t expiring in ttl milliseconds, or -1
+// for keys without TTL.
+func (f *ImportFilter) Keep(key string, t string, ttl int64) bool {
+	if f.Match != "" {
+		if !StringMatch(f.Match, key) {
+			return false
+		}
+		atomic.AddInt64(&f.Matched, 1)
+	}
+	for i, pattern := range f.Excludes {
+		if StringMatch(pattern, key) {
+			atomic.AddInt64(&f.Excluded[i], 1)
+			return false
+		}
+	}
+	if f.MinTTL > 0 && ttl >= 0 && ttl < f.MinTTL*1000 {
+		atomic.AddInt64(&f.ShortTTL, 1)
+		return false
+	}
+	if len(f.Types) > 0 && !f.Types[t] {
+		atomic.AddInt64(&f.OtherType, 1)
+		return false
+	}
+	return true
+}
+
+// Clear the counters, once the keys were listed before the import.
+func (f *ImportFilter) Reset() {
+	atomic.StoreInt64(&f.Matched, 0)
+	atomic.StoreInt64(&f.ShortTTL, 0)
+	atomic.StoreInt64(&f.OtherType, 0)
+	for i := range f.Excluded {
+		atomic.StoreInt64(&f.Excluded[i], 0)
+	}
+	for _, rule := range f.Rules {
+		atomic.StoreInt64(&rule.Matched, 0)
+	}
+}
+
+func (f *ImportFilter) Show() {
+	if f.Match == "" && len(f.Excludes) == 0 && f.MinTTL <= 0 && len(f.Types) == 0 && len(f.Rules) == 0 {
+		return
+	}
+
+	logrus.Printf(">>> Filters and rename rules")
+	if f.Match != "" {
+		logrus.Printf("\tmatch %q: %d keys matched", f.Match, atomic.LoadInt64(&f.Matched))
+	}
+	for i, pattern := range f.Excludes {
+		logrus.Printf("\texclude %q: %d keys excluded", pattern, atomic.LoadInt64(&f.Excluded[i]))
+	}
+	if f.MinTTL > 0 {
+		logrus.Printf("\tmin-ttl %ds: %d keys excluded", f.MinTTL, atomic.LoadInt64(&f.ShortTTL))
+	}
+	if len(f.Types) > 0 {
+		types := make([]string, 0, len(f.Types))
+		for _, t := range ScanKeyTypes {
+			if f.Types[t] {
+				types = append(types, t)
+			}
+		}
+		logrus.Printf("\ttypes %s: %d keys excluded", strings.Join(types, ","), atomic.LoadInt64(&f.OtherType))
+	}
+	for _, rule := range f.Rules {
+		logrus.Printf("\trename %q -> %q: %d keys renamed", rule.Regexp.String(), rule.Template, atomic.LoadInt64(&rule.Matched))
+	}
+}
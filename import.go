@@ -3,16 +3,46 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 	"github.com/garyburd/redigo/redis"
 )
 
+const (
+	ImportMaxReportedSlots = 20
+	ImportMaxSamples       = 10
+	// Upper bound of the "databases" setting accepted for --source-db.
+	ImportMaxSourceDb = 1 << 16
+)
+
 // import          host:port
 //                  --from <arg>
+//                  --rdb <arg>
+//                  --from-cluster <arg>
+//                  --manifest <arg>
 //                  --copy
 //                  --replace
+//                  --pipeline <arg>
+//                  --workers <arg>
+//                  --method <arg>
+//                  --source-db <arg>
+//                  --prefix-template <arg>
+//                  --hashtag-depth <arg>
+//                  --delimiter <arg>
+//                  --dry-run
+//                  --match <arg>
+//                  --exclude <arg>
+//                  --min-ttl <arg>
+//                  --types <arg>
+//                  --rename-rules <arg>
 var importCommand = cli.Command{
 	Name:        "import",
 	Usage:       "import operation for redis cluster.",
@@ -21,7 +51,19 @@ var importCommand = cli.Command{
 	Flags: []cli.Flag{
 		cli.StringFlag{
 			Name:  "from",
-			Usage: `Start slot redis cluster.`,
+			Usage: `Standalone redis instance to import.`,
+		},
+		cli.StringFlag{
+			Name:  "rdb",
+			Usage: `RDB file to import instead of a live instance.`,
+		},
+		cli.StringFlag{
+			Name:  "from-cluster",
+			Usage: `Node of a redis cluster to import, all its masters are scanned.`,
+		},
+		cli.StringFlag{
+			Name:  "manifest",
+			Usage: `Manifest of an export to import, or its directory.`,
 		},
 		cli.BoolFlag{
 			Name:  "copy",
@@ -31,6 +73,64 @@ var importCommand = cli.Command{
 			Name:  "replace",
 			Usage: `Replace flag for import operation.`,
 		},
+		cli.IntFlag{
+			Name:  "pipeline",
+			Value: ImportDefaultPipeline,
+			Usage: `Number of keys sent to a node at once.`,
+		},
+		cli.IntFlag{
+			Name:  "workers",
+			Value: ImportDefaultWorkers,
+			Usage: `Number of workers writing to every master.`,
+		},
+		cli.StringFlag{
+			Name:  "method",
+			Value: ImportMethodMigrate,
+			Usage: `Copy keys with migrate, falling back to dump when the source can't reach a master, or always with dump.`,
+		},
+		cli.StringFlag{
+			Name:  "source-db",
+			Value: "0",
+			Usage: `Databases of the source to import, like 0,3,5.`,
+		},
+		cli.StringFlag{
+			Name:  "prefix-template",
+			Value: "",
+			Usage: `Prefix prepended to the imported keys, {db} is replaced by the source database, like '{db}:'.`,
+		},
+		cli.IntFlag{
+			Name:  "hashtag-depth",
+			Usage: `Wrap this many parts of the key names in a hashtag, to keep related keys in the same slot.`,
+		},
+		cli.StringFlag{
+			Name:  "delimiter",
+			Value: ":",
+			Usage: `Delimiter between the parts of the key names for --hashtag-depth.`,
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: `Only report the keys to import, their new names and the collisions.`,
+		},
+		cli.StringFlag{
+			Name:  "match",
+			Usage: `Only import the keys matching this glob-style pattern, like 'user:*'.`,
+		},
+		cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: `Skip the keys matching this glob-style pattern, may be repeated.`,
+		},
+		cli.Int64Flag{
+			Name:  "min-ttl",
+			Usage: `Skip the keys expiring in less than this many seconds.`,
+		},
+		cli.StringFlag{
+			Name:  "types",
+			Usage: `Only import the keys of these types, like hash,zset.`,
+		},
+		cli.StringFlag{
+			Name:  "rename-rules",
+			Usage: `File of "regexp template" lines renaming the keys, the first matching rule applies.`,
+		},
 		cli.StringFlag{
 			Name:  "password, a",
 			Value: "",
@@ -60,19 +160,73 @@ func (rt *RedisTrib) ImportClusterCmd(context *cli.Context) error {
 	var addr string
 	var source string
 
-	if source = context.String("from"); source == "" {
-		logrus.Fatalf("Option \"--from\" is required for import command!")
+	rdb := context.String("rdb")
+	fromCluster := context.String("from-cluster")
+	manifest := context.String("manifest")
+	if source = context.String("from"); source == "" && rdb == "" && fromCluster == "" && manifest == "" {
+		logrus.Fatalf("Option \"--from\", \"--rdb\", \"--from-cluster\" or \"--manifest\" is required for import command!")
 	} else if addr = context.Args().Get(0); addr == "" {
 		return errors.New("please check host:port for import command")
 	}
 
-	useCopy := context.Bool("copy")
-	useReplace := context.Bool("replace")
+	opts := &ImportOpts{
+		Copy:     context.Bool("copy"),
+		Replace:  context.Bool("replace"),
+		Pipeline: context.Int("pipeline"),
+		Workers:  context.Int("workers"),
+		Method:   strings.ToLower(context.String("method")),
+	}
+	if opts.Method != ImportMethodMigrate && opts.Method != ImportMethodDump {
+		logrus.Fatalf("Invalid method %q for import: migrate or dump.", opts.Method)
+	}
+
+	dbs, err := ParseNumRange(context.String("source-db"), ImportMaxSourceDb)
+	if err != nil || len(dbs) == 0 {
+		logrus.Fatalf("Invalid source-db %q for import, like 0,3,5.", context.String("source-db"))
+	}
+	if (fromCluster != "" || manifest != "") && (len(dbs) != 1 || dbs[0] != 0) {
+		logrus.Fatalf("A redis cluster only has the database 0.")
+	}
+	validTypes := make(map[string]bool)
+	for _, t := range ScanKeyTypes {
+		validTypes[t] = true
+	}
+	var types []string
+	if context.String("types") != "" {
+		for _, t := range strings.Split(context.String("types"), ",") {
+			t = strings.ToLower(strings.TrimSpace(t))
+			if !validTypes[t] {
+				logrus.Fatalf("Invalid type %q for import: %s.", t, strings.Join(ScanKeyTypes, ", "))
+			}
+			types = append(types, t)
+		}
+	}
+	filter := NewImportFilter(context.String("match"), context.StringSlice("exclude"), context.Int64("min-ttl"), types)
+	if path := context.String("rename-rules"); path != "" {
+		if filter.Rules, err = LoadRenameRules(path); err != nil {
+			logrus.Fatalf("Load rename rules failed: %s", err.Error())
+		}
+	}
+
+	renamer := &KeyRenamer{
+		Rules:        filter.Rules,
+		Template:     context.String("prefix-template"),
+		HashtagDepth: context.Int("hashtag-depth"),
+		Delimiter:    context.String("delimiter"),
+	}
+	if !renamer.IsIdentity() {
+		opts.Rename = renamer.Rename
+	}
 
+	if rdb != "" {
+		source = rdb
+	} else if fromCluster != "" {
+		source = fromCluster
+	} else if manifest != "" {
+		source = manifest
+	}
 	logrus.Printf(">>> Importing data from %s to cluster %s", source, addr)
 
-	// Load nodes info before parsing options, otherwise we can't
-	// handle --weight.
 	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
 		return err
 	}
@@ -80,64 +234,515 @@ func (rt *RedisTrib) ImportClusterCmd(context *cli.Context) error {
 	// Check cluster, only proceed if it looks sane.
 	rt.CheckCluster(false)
 
-	// Connect to the source node.
-	logrus.Printf(">>> Connecting to the source Redis instance")
-	srcNode := NewClusterNode(source)
+	// The sources, and a way to list their keys to check the names
+	// they get in the cluster.
+	var sources []*ImportSource
+	var src *RedisTrib
+	var each func(f func(db int, key string) error) error
+	var export *ExportManifest
+	var exportDir string
+	if rdb != "" {
+		each = func(f func(db int, key string) error) error {
+			return EachRdbKey(rdb, dbs, filter, f)
+		}
+	} else if manifest != "" {
+		if export, exportDir, err = ReadExportManifest(manifest); err != nil {
+			return err
+		}
+		logrus.Printf(">>> Export of %s created %s: %d keys in %d %s files", export.Cluster, export.Created, export.Keys, len(export.Files), export.Format)
+		each = func(f func(db int, key string) error) error {
+			now := time.Now().UnixNano() / int64(time.Millisecond)
+			return EachExportEntry(export, exportDir, func(e *RdbEntry) error {
+				if ttl, expired := EntryTTL(e, now); expired || !filter.Keep(e.Key, e.TypeName(), ttl) {
+					return nil
+				}
+				return f(0, e.Key)
+			})
+		}
+	} else {
+		if fromCluster != "" {
+			src = NewRedisTrib()
+			if err := src.LoadClusterInfoFromNode(fromCluster); err != nil {
+				return err
+			}
+			src.CheckCluster(true)
+			if len(src.Errors()) > 0 {
+				logrus.Fatalf("*** Please fix the source cluster problem before importing.")
+			}
+			for _, node := range src.masters() {
+				sources = append(sources, &ImportSource{Node: node, Cluster: true})
+			}
+		} else {
+			// Connect to the source node.
+			logrus.Printf(">>> Connecting to the source Redis instance")
+			srcNode := NewClusterNode(source)
+			srcNode.Connect(true)
+			defer srcNode.Close()
 
-	if srcNode.AssertCluster() {
-		logrus.Errorf("The source node should not be a cluster node.")
+			if srcNode.AssertCluster() {
+				logrus.Fatalf("The source node should not be a cluster node, use --from-cluster.")
+			}
+			for _, db := range dbs {
+				sources = append(sources, &ImportSource{Node: srcNode, DB: db})
+			}
+		}
+		each = func(f func(db int, key string) error) error {
+			return EachSourceKey(sources, filter, f)
+		}
 	}
-	dbsize, _ := srcNode.Dbsize()
-	logrus.Printf("*** Importing %d keys from DB 0", dbsize)
 
-	// Build a slot -> node map
-	slots := make(map[int]*ClusterNode)
-	for _, node := range rt.Nodes() {
-		for key, _ := range node.Slots() {
-			slots[key] = node
+	// Keys of several databases, or renamed, may collide.
+	if context.Bool("dry-run") || len(dbs) > 1 || opts.Rename != nil {
+		report, err := rt.CheckImportNames(each, opts.Rename)
+		if err != nil {
+			return err
+		}
+		report.Show()
+
+		if context.Bool("dry-run") {
+			filter.Show()
+			return nil
 		}
+		if report.Collisions > 0 {
+			logrus.Fatalf("*** %d keys would collide in the cluster, please change the key renaming.", report.Collisions)
+		}
+		filter.Reset()
 	}
 
-	// Use SCAN to iterate over the keys, migrating to the
-	// right node as needed.
-	var keys []string
-	cursor := 0
-	for {
-		// we scan with our iter offset, starting at 0
-		if arr, err := redis.MultiBulk(srcNode.R().Do("SCAN", cursor)); err != nil {
-			logrus.Errorf("Do scan in import cmd failed: %s", err.Error())
-		} else {
-			// now we get the iter and the keys from the multi-bulk reply
-			cursor, _ = redis.Int(arr[0], nil)
-			keys, _ = redis.Strings(arr[1], nil)
+	if rdb != "" {
+		err = rt.ImportRdb(rdb, dbs, filter, opts)
+	} else if export != nil {
+		err = rt.ImportExport(export, exportDir, filter, opts)
+	} else {
+		err = rt.ImportFromNodes(sources, filter, opts)
+	}
+	filter.Show()
+	if err != nil {
+		return err
+	}
+	if src != nil {
+		// Filtered or renamed keys don't keep the count of the slots.
+		if filter.Selective() || opts.Rename != nil {
+			logrus.Printf("*** Keys filtered or renamed, not comparing the keys of every slot.")
+			return nil
 		}
-		// check if we need to stop...
-		if cursor == 0 {
+		return rt.CompareSlotsKeyCount(src, opts.Copy)
+	}
+	return nil
+}
+
+// Restore the keys of the databases of an RDB file into the cluster,
+// keeping their TTL.
+func (rt *RedisTrib) ImportRdb(path string, dbs []int, filter *ImportFilter, opts *ImportOpts) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := NewRdbReader(file)
+	if err != nil {
+		return fmt.Errorf("read %s failed: %s", path, err.Error())
+	}
+	logrus.Printf(">>> Reading %s, RDB version %d", path, reader.Version())
+
+	selected := make(map[int]bool)
+	for _, db := range dbs {
+		selected[db] = true
+	}
+
+	im := rt.NewImporter(opts)
+	var expired, otherDB int64
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
 			break
+		} else if err != nil {
+			im.Close()
+			return fmt.Errorf("read %s failed: %s", path, err.Error())
 		}
 
-		var cmd []interface{}
-		for _, key := range keys {
-			slot := Key2Slot(key)
-			target := slots[int(slot)]
-			logrus.Printf("Migrating %s to %s - OK", key, target.String())
+		if !selected[entry.DB] {
+			otherDB++
+			continue
+		}
+
+		ttl, isExpired := EntryTTL(entry, now)
+		if isExpired {
+			expired++
+			continue
+		}
+		if !filter.Keep(entry.Key, entry.TypeName(), ttl) {
+			continue
+		}
+		im.RestoreKey(im.Name(entry.DB, entry.Key), restoreTTL(ttl), entry.Payload())
+	}
+	im.Close()
+
+	if otherDB > 0 {
+		logrus.Warnf("*** %d keys of other databases were skipped, see --source-db.", otherDB)
+	}
+	if expired > 0 {
+		logrus.Printf("*** %d expired keys were skipped.", expired)
+	}
+	return im.Report()
+}
 
-			cmd = append(cmd, target.Host(), target.Port(), key, 0, MigrateDefaultTimeout)
+// Restore the keys of the files of an export into the cluster, keeping
+// their TTL.
+func (rt *RedisTrib) ImportExport(m *ExportManifest, dir string, filter *ImportFilter, opts *ImportOpts) error {
+	im := rt.NewImporter(opts)
+	var expired int64
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	err := EachExportEntry(m, dir, func(e *RdbEntry) error {
+		ttl, isExpired := EntryTTL(e, now)
+		if isExpired {
+			expired++
+			return nil
+		}
+		if filter.Keep(e.Key, e.TypeName(), ttl) {
+			im.RestoreKey(im.Name(0, e.Key), restoreTTL(ttl), e.Payload())
+		}
+		return nil
+	})
+	im.Close()
 
-			if useCopy {
-				cmd = append(cmd, useCopy)
+	if expired > 0 {
+		logrus.Printf("*** %d expired keys were skipped.", expired)
+	}
+	if rerr := im.Report(); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return err
+	}
+	logrus.Printf(">>> %d keys were exported from %s", m.Keys, m.Cluster)
+	return nil
+}
+
+// Return the TTL in milliseconds of a key read from a file, -1 for keys
+// without expire, and whether it expired already.
+func EntryTTL(e *RdbEntry, now int64) (int64, bool) {
+	if e.ExpireAt <= 0 {
+		return -1, false
+	}
+	ttl := e.ExpireAt - now
+	return ttl, ttl <= 0
+}
+
+// RESTORE takes 0 for keys without expire.
+func restoreTTL(ttl int64) int64 {
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// Import the keys of the sources, scanning them in parallel.
+func (rt *RedisTrib) ImportFromNodes(sources []*ImportSource, filter *ImportFilter, opts *ImportOpts) error {
+	im := rt.NewImporter(opts)
+	errs := make([]error, len(sources))
+	scan := filter.scanOpts()
+
+	logrus.Printf(">>> Importing keys of %d sources with %d workers per master", len(sources), opts.Workers)
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src *ImportSource) {
+			defer wg.Done()
+			conn, err := src.Connect()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer conn.Close()
+
+			errs[i] = conn.ScanKeys(scan, func(keys []*ScannedKey) error {
+				names := make([]string, 0, len(keys))
+				for _, key := range keys {
+					if filter.Keep(key.Key, key.Type, key.TTL) {
+						names = append(names, key.Key)
+					}
+				}
+				im.ImportKeys(src, names)
+				return nil
+			})
+		}(i, src)
+	}
+	wg.Wait()
+	im.Close()
+	rerr := im.Report()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("scan of %s db %d failed: %s", sources[i].Node.String(), sources[i].DB, err.Error())
+		}
+	}
+	return rerr
+}
+
+// Call f with every key of the sources.
+func EachSourceKey(sources []*ImportSource, filter *ImportFilter, f func(db int, key string) error) error {
+	scan := filter.scanOpts()
+	for _, src := range sources {
+		conn, err := src.Connect()
+		if err != nil {
+			return err
+		}
+		err = conn.ScanKeys(scan, func(keys []*ScannedKey) error {
+			for _, key := range keys {
+				if !filter.Keep(key.Key, key.Type, key.TTL) {
+					continue
+				}
+				if err := f(src.DB, key.Key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("scan of %s db %d failed: %s", src.Node.String(), src.DB, err.Error())
+		}
+	}
+	return nil
+}
+
+// Call f with every key of the databases of an RDB file.
+func EachRdbKey(path string, dbs []int, filter *ImportFilter, f func(db int, key string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := NewRdbReader(file)
+	if err != nil {
+		return fmt.Errorf("read %s failed: %s", path, err.Error())
+	}
+	selected := make(map[int]bool)
+	for _, db := range dbs {
+		selected[db] = true
+	}
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read %s failed: %s", path, err.Error())
+		}
+		if !selected[entry.DB] {
+			continue
+		}
+
+		if ttl, expired := EntryTTL(entry, now); expired || !filter.Keep(entry.Key, entry.TypeName(), ttl) {
+			continue
+		}
+		if err := f(entry.DB, entry.Key); err != nil {
+			return err
+		}
+	}
+}
+
+// Rename the keys of the source databases: the rename rules apply
+// first, then the first parts of the key may be wrapped in a hashtag to
+// keep related keys in the same slot, and a prefix made from the
+// template is prepended.
+type KeyRenamer struct {
+	Rules        []*RenameRule
+	Template     string // "{db}" is replaced by the database number
+	HashtagDepth int
+	Delimiter    string
+}
+
+func (r *KeyRenamer) IsIdentity() bool {
+	return len(r.Rules) == 0 && r.Template == "" && r.HashtagDepth <= 0
+}
+
+func (r *KeyRenamer) Rename(db int, key string) string {
+	key = ApplyRenameRules(r.Rules, key)
+
+	// Keys with a hashtag already are kept in their slot.
+	if r.HashtagDepth > 0 && HashTag(key) == key {
+		if prefix := KeyPrefix(key, r.Delimiter, r.HashtagDepth); prefix != "" {
+			tag := prefix[:len(prefix)-len(r.Delimiter)]
+			key = HASHTAG_START + tag + HASHTAG_END + key[len(tag):]
+		}
+	}
+	return strings.Replace(r.Template, "{db}", strconv.Itoa(db), -1) + key
+}
+
+// The names the keys of the sources get in the cluster.
+type ImportNamesReport struct {
+	Keys             map[int]int64 // by source database
+	Renamed          []string
+	Collisions       int64
+	CollisionSamples []string
+	Existing         int64
+	ExistingSamples  []string
+}
+
+// List the keys of the sources to find the ones getting the same name
+// in the cluster, and the ones already in the cluster.
+func (rt *RedisTrib) CheckImportNames(each func(f func(db int, key string) error) error, rename func(db int, key string) string) (*ImportNamesReport, error) {
+	report := &ImportNamesReport{Keys: make(map[int]int64)}
+	origins := make(map[string]string)
+	byTarget := make(map[*ClusterNode][]string)
+	slots := make(map[int]*ClusterNode)
+	for _, node := range rt.masters() {
+		for slot := range node.Slots() {
+			slots[slot] = node
+		}
+	}
+
+	logrus.Printf(">>> Checking the names of the keys in the cluster")
+	err := each(func(db int, key string) error {
+		report.Keys[db]++
+		name := key
+		if rename != nil {
+			name = rename(db, key)
+		}
+		origin := fmt.Sprintf("db%d:%s", db, key)
+		if name != key && len(report.Renamed) < ImportMaxSamples {
+			report.Renamed = append(report.Renamed, fmt.Sprintf("%s -> %s", origin, name))
+		}
+
+		if first, ok := origins[name]; ok {
+			report.Collisions++
+			if len(report.CollisionSamples) < ImportMaxSamples {
+				report.CollisionSamples = append(report.CollisionSamples, fmt.Sprintf("%s <- %s, %s", name, first, origin))
+			}
+			return nil
+		}
+		origins[name] = origin
+		if target, ok := slots[int(Key2Slot(name))]; ok {
+			byTarget[target] = append(byTarget[target], name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys already in the cluster.
+	for target, names := range byTarget {
+		for len(names) > 0 {
+			batch := names
+			if len(batch) > ScanDefaultCount {
+				batch = names[:ScanDefaultCount]
 			}
+			names = names[len(batch):]
 
-			if useReplace {
-				cmd = append(cmd, useReplace)
+			for _, name := range batch {
+				target.R().Send("EXISTS", name)
+			}
+			if err := target.R().Flush(); err != nil {
+				return nil, err
+			}
+			for _, name := range batch {
+				exists, err := redis.Bool(target.R().Receive())
+				if err != nil {
+					return nil, err
+				}
+				if exists {
+					report.Existing++
+					if len(report.ExistingSamples) < ImportMaxSamples {
+						report.ExistingSamples = append(report.ExistingSamples, name)
+					}
+				}
 			}
+		}
+	}
+	return report, nil
+}
+
+func (r *ImportNamesReport) Show() {
+	dbs := make([]int, 0, len(r.Keys))
+	for db := range r.Keys {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	for _, db := range dbs {
+		logrus.Printf("\tdb%d: %d keys", db, r.Keys[db])
+	}
 
-			if _, err := srcNode.Call("migrate", cmd...); err != nil {
-				logrus.Printf("Migrating %s to %s - %s", key, target.String(), err.Error())
-			} else {
-				logrus.Printf("Migrating %s to %s - OK", key, target.String())
+	if len(r.Renamed) > 0 {
+		logrus.Printf("  Renamed keys:")
+		for _, sample := range r.Renamed {
+			logrus.Printf("\t%s", sample)
+		}
+	}
+
+	if r.Collisions > 0 {
+		logrus.Warnf("*** %d keys collide with an other key:", r.Collisions)
+		for _, sample := range r.CollisionSamples {
+			logrus.Printf("\t%s", sample)
+		}
+	} else {
+		logrus.Printf("[OK] No collision between the imported keys.")
+	}
+
+	if r.Existing > 0 {
+		logrus.Warnf("*** %d keys already exist in the cluster, they are kept without --replace:", r.Existing)
+		for _, name := range r.ExistingSamples {
+			logrus.Printf("\t%s", name)
+		}
+	} else {
+		logrus.Printf("[OK] None of the imported keys is in the cluster.")
+	}
+}
+
+// Compare the number of keys of every slot in the source cluster and
+// in this one. Once moved, the keys are expected to be gone from the
+// source.
+func (rt *RedisTrib) CompareSlotsKeyCount(src *RedisTrib, copied bool) error {
+	count := func(r *RedisTrib) (map[int]int, error) {
+		counts := make(map[int]int)
+		for _, node := range r.masters() {
+			c, err := node.SlotsKeyCount()
+			if err != nil {
+				return nil, fmt.Errorf("count keys of %s failed: %s", node.String(), err.Error())
+			}
+			for slot, n := range c {
+				counts[slot] += n
 			}
 		}
+		return counts, nil
+	}
+
+	srcCounts, err := count(src)
+	if err != nil {
+		return err
+	}
+	dstCounts, err := count(rt)
+	if err != nil {
+		return err
+	}
+
+	logrus.Printf(">>> Comparing the keys of every slot")
+	var srcTotal, dstTotal int
+	var diffs []int
+	for slot := 0; slot < ClusterHashSlots; slot++ {
+		srcTotal += srcCounts[slot]
+		dstTotal += dstCounts[slot]
+		if (copied && srcCounts[slot] != dstCounts[slot]) || (!copied && srcCounts[slot] != 0) {
+			diffs = append(diffs, slot)
+		}
+	}
+
+	for i, slot := range diffs {
+		if i >= ImportMaxReportedSlots {
+			logrus.Printf("\t... and %d other slots.", len(diffs)-i)
+			break
+		}
+		logrus.Printf("\tslot %-5d | source %d keys | target %d keys", slot, srcCounts[slot], dstCounts[slot])
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%d slots differ, source has %d keys, target %d keys", len(diffs), srcTotal, dstTotal)
 	}
+	logrus.Printf("[OK] All slots match, source has %d keys, target %d keys.", srcTotal, dstTotal)
 	return nil
 }
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	ImportDefaultPipeline = 100
+	ImportDefaultWorkers  = 4
+	ImportMethodMigrate   = "migrate"
+	ImportMethodDump      = "dump"
+)
+
+type ImportOpts struct {
+	Copy     bool   // keep the keys in the source
+	Replace  bool   // replace the keys already in the cluster
+	Pipeline int    // keys sent at once with MIGRATE or RESTORE
+	Workers  int    // workers per target master
+	Method   string // migrate or dump
+
+	// Name of a key of a source database in the cluster, nil to keep
+	// the names. Keys are only moved with MIGRATE when they keep their
+	// names.
+	Rename func(db int, key string) string
+}
+
+// A database of a node to import. The multi-key commands of cluster
+// nodes only accept keys of a single slot.
+type ImportSource struct {
+	Node    *ClusterNode
+	DB      int
+	Cluster bool
+}
+
+// Open a new connection to the database of the source.
+func (src *ImportSource) Connect() (*ClusterNode, error) {
+	node := NewClusterNode(src.Node.String())
+	if err := node.Connect(false); err != nil {
+		return nil, err
+	}
+	if src.DB != 0 {
+		if _, err := node.R().Do("SELECT", src.DB); err != nil {
+			node.Close()
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// Counters of an import, updated atomically by the workers.
+type ImportStats struct {
+	Migrated int64
+	Restored int64
+	Skipped  int64
+	Failed   int64
+}
+
+// Import keys into the cluster. Keys are grouped by target master and
+// handed to the workers of that master, which copy them from their
+// source with a multi-key MIGRATE, or with pipelined DUMP and RESTORE
+// when the source can't reach the target. Keys read from a file are
+// restored directly.
+type Importer struct {
+	// First for the 64 bit alignment of the atomic counters.
+	stats ImportStats
+
+	opts  *ImportOpts
+	slots map[int]*ClusterNode
+	jobs  map[*ClusterNode]chan *importJob
+	wg    sync.WaitGroup
+	start time.Time
+
+	// Source/target pairs falling back to DUMP and RESTORE.
+	mu          sync.Mutex
+	unreachable map[[2]*ClusterNode]bool
+
+	// Keys waiting to be restored, by target.
+	pending map[*ClusterNode]*importJob
+}
+
+// Keys of the same target, to copy from source, or to restore from
+// their payloads when there is no source. Keys are named names in the
+// cluster.
+type importJob struct {
+	source   *ImportSource
+	target   *ClusterNode
+	keys     []string
+	names    []string
+	ttls     []int64
+	payloads [][]byte
+}
+
+// Create an importer and start the workers of every master.
+func (rt *RedisTrib) NewImporter(opts *ImportOpts) *Importer {
+	if opts.Pipeline <= 0 {
+		opts.Pipeline = ImportDefaultPipeline
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = ImportDefaultWorkers
+	}
+	if opts.Method == "" {
+		opts.Method = ImportMethodMigrate
+	}
+
+	im := &Importer{
+		opts:        opts,
+		slots:       make(map[int]*ClusterNode),
+		jobs:        make(map[*ClusterNode]chan *importJob),
+		start:       time.Now(),
+		unreachable: make(map[[2]*ClusterNode]bool),
+		pending:     make(map[*ClusterNode]*importJob),
+	}
+	for _, node := range rt.masters() {
+		for slot := range node.Slots() {
+			im.slots[slot] = node
+		}
+
+		im.jobs[node] = make(chan *importJob, opts.Workers*2)
+		for i := 0; i < opts.Workers; i++ {
+			im.wg.Add(1)
+			go im.worker(im.jobs[node])
+		}
+	}
+	return im
+}
+
+// Return the master serving the slot of the key, nil if the slot is
+// not covered.
+func (im *Importer) Target(key string) *ClusterNode {
+	return im.slots[int(Key2Slot(key))]
+}
+
+func (im *Importer) targetOrFail(key string) *ClusterNode {
+	target := im.Target(key)
+	if target == nil {
+		logrus.Errorf("Importing %s - slot %d is not covered", key, Key2Slot(key))
+		atomic.AddInt64(&im.stats.Failed, 1)
+	}
+	return target
+}
+
+// Return the name of a key of a source database in the cluster.
+func (im *Importer) Name(db int, key string) string {
+	if im.opts.Rename == nil {
+		return key
+	}
+	return im.opts.Rename(db, key)
+}
+
+// Queue keys to copy from the source, it may be called from several
+// goroutines.
+func (im *Importer) ImportKeys(source *ImportSource, keys []string) {
+	byTarget := make(map[*ClusterNode]*importJob)
+	var targets []*ClusterNode
+	for _, key := range keys {
+		name := im.Name(source.DB, key)
+		target := im.targetOrFail(name)
+		if target == nil {
+			continue
+		}
+		job, ok := byTarget[target]
+		if !ok {
+			job = &importJob{source: source, target: target}
+			byTarget[target] = job
+			targets = append(targets, target)
+		}
+		job.keys = append(job.keys, key)
+		job.names = append(job.names, name)
+
+		if len(job.keys) >= im.opts.Pipeline {
+			im.jobs[target] <- job
+			byTarget[target] = &importJob{source: source, target: target}
+		}
+	}
+
+	for _, target := range targets {
+		if job := byTarget[target]; len(job.keys) > 0 {
+			im.jobs[target] <- job
+		}
+	}
+}
+
+// Queue a key to restore from its payload, with its name in the
+// cluster and its TTL in milliseconds or 0. It must be called from a
+// single goroutine.
+func (im *Importer) RestoreKey(key string, ttl int64, payload []byte) {
+	target := im.targetOrFail(key)
+	if target == nil {
+		return
+	}
+
+	job, ok := im.pending[target]
+	if !ok {
+		job = &importJob{target: target}
+		im.pending[target] = job
+	}
+	job.keys = append(job.keys, key)
+	job.names = append(job.names, key)
+	job.ttls = append(job.ttls, ttl)
+	job.payloads = append(job.payloads, payload)
+	if len(job.keys) >= im.opts.Pipeline {
+		delete(im.pending, target)
+		im.jobs[target] <- job
+	}
+}
+
+// Count a key skipped before reaching the importer.
+func (im *Importer) Skip() {
+	atomic.AddInt64(&im.stats.Skipped, 1)
+}
+
+// Send the keys still queued, and wait for the workers to finish.
+func (im *Importer) Close() ImportStats {
+	for target, job := range im.pending {
+		im.jobs[target] <- job
+	}
+	im.pending = nil
+
+	for _, jobs := range im.jobs {
+		close(jobs)
+	}
+	im.wg.Wait()
+	return im.stats
+}
+
+// Print the stats of the import, and return an error if some keys
+// failed to be imported.
+func (im *Importer) Report() error {
+	elapsed := time.Since(im.start)
+	imported := im.stats.Migrated + im.stats.Restored
+	summary := fmt.Sprintf("%d keys imported (%d migrated, %d restored), %d skipped, %d failed in %s, %.0f keys/sec.",
+		imported, im.stats.Migrated, im.stats.Restored, im.stats.Skipped, im.stats.Failed,
+		elapsed.Round(time.Millisecond), float64(imported)/elapsed.Seconds())
+	if im.stats.Failed > 0 {
+		logrus.Errorf("*** %s", summary)
+		return fmt.Errorf("%d keys failed to be imported", im.stats.Failed)
+	}
+	logrus.Printf("[OK] %s", summary)
+	return nil
+}
+
+func (im *Importer) isUnreachable(source *ImportSource, target *ClusterNode) bool {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	return im.unreachable[[2]*ClusterNode{source.Node, target}]
+}
+
+func (im *Importer) setUnreachable(source *ImportSource, target *ClusterNode) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.unreachable[[2]*ClusterNode{source.Node, target}] = true
+}
+
+// A worker has its own connections to the target and to the sources.
+func (im *Importer) worker(jobs chan *importJob) {
+	defer im.wg.Done()
+
+	w := &importWorker{
+		targets: make(map[*ClusterNode]*ClusterNode),
+		sources: make(map[*ImportSource]*ClusterNode),
+	}
+	defer w.close()
+
+	for job := range jobs {
+		if err := im.run(w, job); err != nil {
+			logrus.Errorf("Importing %d keys to %s - %s", len(job.keys), job.target.String(), err.Error())
+			atomic.AddInt64(&im.stats.Failed, int64(len(job.keys)))
+
+			// Reconnect for the next jobs.
+			w.close()
+		}
+	}
+}
+
+type importWorker struct {
+	targets map[*ClusterNode]*ClusterNode
+	sources map[*ImportSource]*ClusterNode
+}
+
+func (w *importWorker) target(node *ClusterNode) (*ClusterNode, error) {
+	conn, ok := w.targets[node]
+	if !ok {
+		conn = NewClusterNode(node.String())
+		if err := conn.Connect(false); err != nil {
+			return nil, err
+		}
+		w.targets[node] = conn
+	}
+	return conn, nil
+}
+
+func (w *importWorker) source(src *ImportSource) (*ClusterNode, error) {
+	conn, ok := w.sources[src]
+	if !ok {
+		var err error
+		if conn, err = src.Connect(); err != nil {
+			return nil, err
+		}
+		w.sources[src] = conn
+	}
+	return conn, nil
+}
+
+func (w *importWorker) close() {
+	for node, conn := range w.targets {
+		conn.Close()
+		delete(w.targets, node)
+	}
+	for src, conn := range w.sources {
+		conn.Close()
+		delete(w.sources, src)
+	}
+}
+
+func (im *Importer) run(w *importWorker, job *importJob) error {
+	target, err := w.target(job.target)
+	if err != nil {
+		return err
+	}
+	if job.source == nil {
+		_, err := im.restore(target, job)
+		return err
+	}
+
+	source, err := w.source(job.source)
+	if err != nil {
+		return err
+	}
+
+	if im.opts.Method == ImportMethodMigrate && im.opts.Rename == nil && !im.isUnreachable(job.source, job.target) {
+		done, err := im.migrate(source, job)
+		if err != nil || done {
+			return err
+		}
+	}
+
+	if err := im.dump(source, job); err != nil {
+		return err
+	}
+	restored, err := im.restore(target, job)
+	if err != nil {
+		return err
+	}
+
+	// Remove the keys from the source once copied when moving them.
+	if im.opts.Copy || len(restored) == 0 {
+		return nil
+	}
+	for _, key := range restored {
+		source.R().Send("DEL", key)
+	}
+	if err := source.R().Flush(); err != nil {
+		return err
+	}
+	for range restored {
+		if _, err := source.R().Receive(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Move the keys of the job with MIGRATE, one per slot for cluster
+// sources. Return false when keys are left to copy with DUMP and
+// RESTORE, the job then only holds them: the source can't reach the
+// target, or the target refused some of the keys.
+func (im *Importer) migrate(source *ClusterNode, job *importJob) (bool, error) {
+	cmd := []interface{}{job.target.Host(), job.target.Port(), "", 0, MigrateDefaultTimeout}
+	if im.opts.Copy {
+		cmd = append(cmd, "COPY")
+	}
+	if im.opts.Replace {
+		cmd = append(cmd, "REPLACE")
+	}
+	if RedisPassword != "" {
+		cmd = append(cmd, "AUTH", RedisPassword)
+	}
+	cmd = append(cmd, "KEYS")
+
+	// Indexes of the keys of every MIGRATE.
+	var batches [][]int
+	if job.source.Cluster {
+		bySlot := make(map[uint16]int)
+		for i, key := range job.keys {
+			slot := Key2Slot(key)
+			if b, ok := bySlot[slot]; ok {
+				batches[b] = append(batches[b], i)
+				continue
+			}
+			bySlot[slot] = len(batches)
+			batches = append(batches, []int{i})
+		}
+	} else {
+		batch := make([]int, len(job.keys))
+		for i := range job.keys {
+			batch[i] = i
+		}
+		batches = append(batches, batch)
+	}
+
+	for _, batch := range batches {
+		args := append([]interface{}{}, cmd...)
+		for _, i := range batch {
+			args = append(args, job.keys[i])
+		}
+		source.R().Send("MIGRATE", args...)
+	}
+	if err := source.R().Flush(); err != nil {
+		return false, err
+	}
+
+	var left []int
+	var failed error
+	for _, batch := range batches {
+		reply, err := redis.String(source.R().Receive())
+		if err == nil {
+			if reply == "NOKEY" {
+				atomic.AddInt64(&im.stats.Skipped, int64(len(batch)))
+			} else {
+				atomic.AddInt64(&im.stats.Migrated, int64(len(batch)))
+			}
+			continue
+		}
+		if _, ok := err.(redis.Error); !ok {
+			return false, err
+		}
+		if failed == nil {
+			failed = err
+		}
+		left = append(left, batch...)
+	}
+	if len(left) == 0 {
+		return true, nil
+	}
+
+	if strings.HasPrefix(failed.Error(), "IOERR") || strings.Contains(failed.Error(), "Target instance replied with error: NOAUTH") {
+		logrus.Warnf("*** %s can't MIGRATE to %s (%s), using DUMP/RESTORE.", job.source.Node.String(), job.target.String(), failed.Error())
+		im.setUnreachable(job.source, job.target)
+	}
+
+	// The caller copies the keys left with DUMP and RESTORE, to know
+	// the fate of every key.
+	sort.Ints(left)
+	keys, names := job.keys, job.names
+	job.keys, job.names = nil, nil
+	for _, i := range left {
+		job.keys = append(job.keys, keys[i])
+		job.names = append(job.names, names[i])
+	}
+	return false, nil
+}
+
+// Read the payload and the TTL of the keys of the job from its source.
+// Keys gone since the SCAN are removed from the job.
+func (im *Importer) dump(source *ClusterNode, job *importJob) error {
+	for _, key := range job.keys {
+		source.R().Send("DUMP", key)
+		source.R().Send("PTTL", key)
+	}
+	if err := source.R().Flush(); err != nil {
+		return err
+	}
+
+	keys, names := job.keys, job.names
+	job.keys, job.names, job.ttls, job.payloads = nil, nil, nil, nil
+	for i, key := range keys {
+		payload, err := redis.Bytes(source.R().Receive())
+		if err != nil && err != redis.ErrNil {
+			return err
+		}
+		ttl, err := redis.Int64(source.R().Receive())
+		if err != nil {
+			return err
+		}
+		if payload == nil || ttl == -2 {
+			atomic.AddInt64(&im.stats.Skipped, 1)
+			continue
+		}
+		if ttl < 0 {
+			ttl = 0
+		}
+		job.keys = append(job.keys, key)
+		job.names = append(job.names, names[i])
+		job.ttls = append(job.ttls, ttl)
+		job.payloads = append(job.payloads, payload)
+	}
+	return nil
+}
+
+// Restore the keys of the job with pipelined RESTORE, and return the
+// source keys restored.
+func (im *Importer) restore(target *ClusterNode, job *importJob) ([]string, error) {
+	if len(job.keys) == 0 {
+		return nil, nil
+	}
+
+	for i, name := range job.names {
+		if im.opts.Replace {
+			target.R().Send("RESTORE", name, job.ttls[i], job.payloads[i], "REPLACE")
+		} else {
+			target.R().Send("RESTORE", name, job.ttls[i], job.payloads[i])
+		}
+	}
+	if err := target.R().Flush(); err != nil {
+		return nil, err
+	}
+
+	var restored []string
+	for i, name := range job.names {
+		_, err := redis.String(target.R().Receive())
+		if err == nil {
+			restored = append(restored, job.keys[i])
+			atomic.AddInt64(&im.stats.Restored, 1)
+			continue
+		}
+		if _, ok := err.(redis.Error); !ok {
+			return restored, err
+		}
+
+		// Without --replace, the keys already in the cluster are kept.
+		if strings.HasPrefix(err.Error(), "BUSYKEY") {
+			atomic.AddInt64(&im.stats.Skipped, 1)
+			continue
+		}
+		logrus.Errorf("Restoring %s to %s - %s", name, job.target.String(), err.Error())
+		atomic.AddInt64(&im.stats.Failed, 1)
+	}
+	return restored, nil
+}
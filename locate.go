@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/garyburd/redigo/redis"
+)
+
+// locate          host:port key1 ... keyN
+var locateCommand = cli.Command{
+	Name:        "locate",
+	Usage:       "show the slot and the nodes owning keys.",
+	ArgsUsage:   `host:port key1 ... keyN`,
+	Description: `The locate command shows the hash slot of every key, its owner and whether the key exists.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is ""`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 2 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "locate")
+			logrus.Fatalf("Must provide \"host:port key1 ... keyN\" for locate command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		rt := NewRedisTrib()
+		if err := rt.LocateClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+func (rt *RedisTrib) LocateClusterCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for locate command")
+	}
+
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return err
+	}
+
+	for _, key := range context.Args()[1:] {
+		rt.LocateKey(key)
+	}
+	return nil
+}
+
+// Show where a key lives in the cluster.
+func (rt *RedisTrib) LocateKey(key string) {
+	slot := int(Key2Slot(key))
+	tag := HashTag(key)
+
+	logrus.Printf(">>> Key %q", key)
+	if tag != key {
+		logrus.Printf("  slot: %d (hashtag %q)", slot, tag)
+	} else {
+		logrus.Printf("  slot: %d (whole key hashed)", slot)
+	}
+
+	owners := rt.GetSlotOwners(slot)
+	if len(owners) == 0 {
+		logrus.Warnf("  [WARNING] Slot %d is not covered by any master.", slot)
+		return
+	} else if len(owners) > 1 {
+		logrus.Warnf("  [WARNING] Slot %d is claimed by %d masters: %s", slot, len(owners), ClusterNodeArray2String(owners))
+	}
+
+	for _, owner := range owners {
+		logrus.Printf("  master: %s (%s)", owner.String(), owner.Name())
+		var replicas []string
+		for _, r := range owner.ReplicasNodes() {
+			replicas = append(replicas, r.String())
+		}
+		if len(replicas) > 0 {
+			logrus.Printf("  replicas: %s", strings.Join(replicas, ", "))
+		} else {
+			logrus.Printf("  replicas: none")
+		}
+
+		exists, err := redis.Bool(owner.Call("EXISTS", key))
+		if err != nil {
+			logrus.Errorf("  EXISTS on %s failed: %s", owner.String(), err.Error())
+		} else {
+			logrus.Printf("  exists on master: %t", exists)
+		}
+
+		// During a migration the key may already be in the importing
+		// node, which only answers to queries preceded by ASKING.
+		if id, ok := owner.Migrating()[slot]; ok {
+			target := rt.GetNodeByName(id)
+			if target == nil {
+				logrus.Printf("  state: migrating to unknown node %s", id)
+				continue
+			}
+			logrus.Printf("  state: migrating to %s (%s)", target.String(), id)
+
+			if err := target.Connect(false); err != nil {
+				continue
+			}
+			target.R().Send("ASKING")
+			target.R().Send("EXISTS", key)
+			if err := target.R().Flush(); err == nil {
+				target.R().Receive()
+				if exists, err := redis.Bool(target.R().Receive()); err == nil {
+					logrus.Printf("  exists on %s: %t", target.String(), exists)
+				}
+			}
+		}
+	}
+
+	for _, node := range rt.Nodes() {
+		if id, ok := node.Importing()[slot]; ok {
+			logrus.Printf("  state: importing in %s from %s", node.String(), id)
+		}
+	}
+}
@@ -65,16 +65,29 @@ var RedisPassword string
 // runtimeCommands is all sub-command
 var runtimeCommands = []cli.Command{
 	addNodeCommand,
+	analyzeCommand,
+	applyCommand,
+	backupCommand,
 	callCommand,
 	checkCommand,
 	createCommand,
 	delNodeCommand,
+	deleteKeysCommand,
+	exportCommand,
 	fixCommand,
+	forgetFailedCommand,
+	hotkeysCommand,
 	importCommand,
 	infoCommand,
+	locateCommand,
 	rebalanceCommand,
 	reshardCommand,
+	scanCommand,
+	serveMetricsCommand,
 	setTimeoutCommand,
+	topCommand,
+	verifyCommand,
+	verifySlotsCommand,
 }
 
 func beforeSubcommands(context *cli.Context) error {
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const PlanFileVersion = 1
+
+// A group of slots moved from a source to a target master.
+type SlotMove struct {
+	Source *ClusterNode
+	Target *ClusterNode
+	Slots  []int
+}
+
+// A resharding plan, shared by reshard and rebalance. Plans are
+// deterministic: the same topology always gives the same moves.
+type ReshardPlan struct {
+	Moves []*SlotMove
+}
+
+// Add slots to the plan, merging them with an existing move between
+// the same nodes.
+func (p *ReshardPlan) Add(source, target *ClusterNode, slots []int) {
+	if len(slots) == 0 {
+		return
+	}
+
+	for _, m := range p.Moves {
+		if m.Source == source && m.Target == target {
+			m.Slots = append(m.Slots, slots...)
+			sort.Ints(m.Slots)
+			return
+		}
+	}
+
+	sorted := append([]int{}, slots...)
+	sort.Ints(sorted)
+	p.Moves = append(p.Moves, &SlotMove{Source: source, Target: target, Slots: sorted})
+}
+
+// Return the number of slots moved by the plan.
+func (p *ReshardPlan) NumSlots() int {
+	num := 0
+	for _, m := range p.Moves {
+		num += len(m.Slots)
+	}
+	return num
+}
+
+// Return the slots served by the node once the plan is executed.
+func (p *ReshardPlan) SlotsAfter(node *ClusterNode) map[int]int {
+	slots := make(map[int]int, len(node.Slots()))
+	for slot, v := range node.Slots() {
+		slots[slot] = v
+	}
+
+	for _, m := range p.Moves {
+		for _, slot := range m.Slots {
+			if m.Source == node {
+				delete(slots, slot)
+			}
+			if m.Target == node {
+				slots[slot] = AssignedHashSlot
+			}
+		}
+	}
+	return slots
+}
+
+// Print the exact slot ranges every move will transfer.
+func (p *ReshardPlan) Show() {
+	for _, m := range p.Moves {
+		logrus.Printf("    Moving %d slots from %s to %s: %s", len(m.Slots),
+			m.Source.String(), m.Target.String(), MergeNumArray2NumRange(m.Slots))
+	}
+}
+
+// Print the slots, and the load when costs are given, of every node
+// before and after the plan.
+func (p *ReshardPlan) ShowDistribution(nodes []*ClusterNode, costs map[int]int64, unit string) {
+	if costs != nil {
+		logrus.Printf("%-22s %16s %20s  %s", "NODE", "SLOTS", unit, "SLOTS AFTER")
+	} else {
+		logrus.Printf("%-22s %16s  %s", "NODE", "SLOTS", "SLOTS AFTER")
+	}
+
+	for _, node := range nodes {
+		after := p.SlotsAfter(node)
+		ranges := MergeNumArray2NumRange(sortedSlots(after))
+		if costs != nil {
+			logrus.Printf("%-22s %7d -> %-6d %9d -> %-9d  %s", node.String(),
+				len(node.Slots()), len(after), SlotsLoad(node.Slots(), costs), SlotsLoad(after, costs), ranges)
+		} else {
+			logrus.Printf("%-22s %7d -> %-6d  %s", node.String(), len(node.Slots()), len(after), ranges)
+		}
+	}
+}
+
+// Execute every move of the plan, then check the sources have no key
+// left in the moved slots and all the masters agree on their owners.
+func (rt *RedisTrib) ExecutePlan(p *ReshardPlan, opts *MoveOpts) error {
+	owners := make(map[int]*ClusterNode)
+	for _, m := range p.Moves {
+		logrus.Printf(">>> Moving %d slots from %s to %s", len(m.Slots), m.Source.String(), m.Target.String())
+		for _, slot := range m.Slots {
+			rt.MoveSlot(&MovedNode{Source: m.Source, Slot: slot}, m.Target, opts)
+			owners[slot] = m.Target
+		}
+	}
+	if opts.Cold || len(owners) == 0 {
+		return nil
+	}
+
+	logrus.Printf(">>> Verifying the owner of the %d moved slots", len(owners))
+	problems, err := rt.VerifySlotsOwner(owners, VerifySlotsDefaultRetries)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			logrus.Errorf("[ERR] %s", p)
+		}
+		return fmt.Errorf("%d problems with the owner of the moved slots", len(problems))
+	}
+	return nil
+}
+
+// Given a list of source nodes return a "resharding plan" with what
+// slots to move in order to move "numSlots" slots to the target.
+func (rt *RedisTrib) PlanReshard(sources []*ClusterNode, target *ClusterNode, numSlots int) *ReshardPlan {
+	plan := &ReshardPlan{}
+
+	// Sort from bigger to smaller instance, for two reasons:
+	// 1) If we take less slots than instances it is better to start
+	//    getting from the biggest instances.
+	// 2) We take one slot more from the first instances in the case of
+	//    not perfect divisibility. Like we have 3 nodes and need to get
+	//    10 slots, we take 4 from the first, and 3 from the rest.
+	srcs := append([]*ClusterNode{}, sources...)
+	sort.SliceStable(srcs, func(i, j int) bool {
+		if len(srcs[i].Slots()) != len(srcs[j].Slots()) {
+			return len(srcs[i].Slots()) > len(srcs[j].Slots())
+		}
+		return srcs[i].Name() < srcs[j].Name()
+	})
+
+	sourceTotSlots := 0
+	for _, node := range srcs {
+		sourceTotSlots += len(node.Slots())
+	}
+	if sourceTotSlots == 0 {
+		return plan
+	}
+	if numSlots > sourceTotSlots {
+		numSlots = sourceTotSlots
+	}
+
+	// Every source gives slots in proportion to the ones it has, the
+	// rounding leftovers are taken from the biggest ones.
+	quota := make([]int, len(srcs))
+	assigned := 0
+	for i, node := range srcs {
+		quota[i] = numSlots * len(node.Slots()) / sourceTotSlots
+		assigned += quota[i]
+	}
+	for i := 0; assigned < numSlots; i = (i + 1) % len(srcs) {
+		if quota[i] < len(srcs[i].Slots()) {
+			quota[i] += 1
+			assigned += 1
+		}
+	}
+
+	for i, node := range srcs {
+		slots, _ := pickSlots(node.Slots(), int64(quota[i]), nil)
+		plan.Add(node, target, slots)
+	}
+	return plan
+}
+
+// Return a plan moving load from the nodes with a positive balance to
+// the ones with a negative balance, the balance being expressed in
+// slots or, if costs are given, in the sum of the costs of the slots.
+// The nodes giving the most are paired with the ones receiving the
+// most, which keeps the number of source/target pairs low.
+func (rt *RedisTrib) PlanRebalance(nodes []*ClusterNode, costs map[int]int64) *ReshardPlan {
+	plan := &ReshardPlan{}
+
+	sn := append(BalanceArray{}, nodes...)
+	sort.Stable(sn)
+
+	balance := make(map[*ClusterNode]int64)
+	for _, node := range sn {
+		balance[node] = node.Balance()
+	}
+
+	// Now we have at the start of the 'sn' array nodes that should get
+	// slots, at the end nodes that must give slots.
+	// We take two indexes, one at the start, and one at the end,
+	// incrementing or decrementing the indexes accordingly til we
+	// find nodes that need to get/provide slots.
+	dstIdx := 0
+	srcIdx := len(sn) - 1
+	for dstIdx < srcIdx {
+		dst := sn[dstIdx]
+		src := sn[srcIdx]
+
+		amount := -balance[dst]
+		if balance[src] < amount {
+			amount = balance[src]
+		}
+
+		var moved int64
+		if amount > 0 {
+			var slots []int
+			slots, moved = pickSlots(plan.SlotsAfter(src), amount, costs)
+			plan.Add(src, dst, slots)
+		}
+
+		// Update nodes balance.
+		balance[dst] += moved
+		balance[src] -= moved
+		if balance[dst] >= 0 {
+			dstIdx += 1
+		}
+		if balance[src] <= 0 {
+			srcIdx -= 1
+		} else if moved == 0 {
+			// None of the slots left in the source fits in what the
+			// destination needs, try with the next source.
+			srcIdx -= 1
+		}
+	}
+	return plan
+}
+
+// Pick slots among 'slots' adding up to 'amount': a number of slots
+// without costs, the sum of their costs otherwise. Slots are taken
+// starting from the highest one so that the moved slots, and the ones
+// left, stay in contiguous ranges. With costs, a slot is skipped if it
+// would overshoot the amount by more than half its cost, and slots
+// without any cost are never moved. Return the slots and the amount
+// actually picked.
+func pickSlots(slots map[int]int, amount int64, costs map[int]int64) ([]int, int64) {
+	var picked []int
+	var total int64
+
+	keys := sortedSlots(slots)
+	for i := len(keys) - 1; i >= 0 && total < amount; i-- {
+		slot := keys[i]
+		if costs == nil {
+			picked = append(picked, slot)
+			total += 1
+			continue
+		}
+
+		cost := costs[slot]
+		remaining := amount - total
+		if cost == 0 || cost-remaining > cost/2 {
+			continue
+		}
+		picked = append(picked, slot)
+		total += cost
+	}
+
+	sort.Ints(picked)
+	return picked, total
+}
+
+func sortedSlots(slots map[int]int) []int {
+	keys := make([]int, 0, len(slots))
+	for slot := range slots {
+		keys = append(keys, slot)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// Return a fingerprint of the slots configuration: the slots served
+// by every master and the open slots. A saved plan can only be applied
+// to a cluster with the same fingerprint.
+func (rt *RedisTrib) TopologyFingerprint() string {
+	var config []string
+	for _, node := range rt.Nodes() {
+		if !node.HasFlag("master") {
+			continue
+		}
+		config = append(config, fmt.Sprintf("%s:%s", node.Name(),
+			MergeNumArray2NumRange(sortedSlots(node.Slots()))))
+	}
+	sort.Strings(config)
+	config = append(config, "open:"+MergeNumArray2NumRange(rt.OpenSlots()))
+
+	sum := sha256.Sum256([]byte(strings.Join(config, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// The JSON representation of a saved plan, meant to be reviewed before
+// being applied.
+type PlanFile struct {
+	Version     int             `json:"version"`
+	Command     string          `json:"command"`
+	Cluster     string          `json:"cluster"`
+	Created     string          `json:"created"`
+	Fingerprint string          `json:"fingerprint"`
+	Moves       []*PlanFileMove `json:"moves"`
+}
+
+type PlanFileMove struct {
+	Source     string `json:"source"`
+	SourceAddr string `json:"source_addr"`
+	Target     string `json:"target"`
+	TargetAddr string `json:"target_addr"`
+	NumSlots   int    `json:"num_slots"`
+	Slots      string `json:"slots"`
+}
+
+// Save the plan, computed by 'command' against the cluster 'addr', to
+// a JSON file.
+func (rt *RedisTrib) SavePlan(p *ReshardPlan, path, command, addr string) error {
+	pf := &PlanFile{
+		Version:     PlanFileVersion,
+		Command:     command,
+		Cluster:     addr,
+		Created:     time.Now().UTC().Format(time.RFC3339),
+		Fingerprint: rt.TopologyFingerprint(),
+	}
+	for _, m := range p.Moves {
+		pf.Moves = append(pf.Moves, &PlanFileMove{
+			Source:     m.Source.Name(),
+			SourceAddr: m.Source.String(),
+			Target:     m.Target.Name(),
+			TargetAddr: m.Target.String(),
+			NumSlots:   len(m.Slots),
+			Slots:      MergeNumArray2NumRange(m.Slots),
+		})
+	}
+
+	data, err := json.MarshalIndent(pf, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	logrus.Printf(">>> Plan with %d slots to move saved to %s", p.NumSlots(), path)
+	return nil
+}
+
+// Read a saved plan file.
+func ReadPlanFile(path string) (*PlanFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pf := &PlanFile{}
+	if err := json.Unmarshal(data, pf); err != nil {
+		return nil, fmt.Errorf("invalid plan file %s: %s", path, err.Error())
+	}
+	if pf.Version != PlanFileVersion {
+		return nil, fmt.Errorf("unsupported plan file version %d", pf.Version)
+	}
+	return pf, nil
+}
+
+// Resolve the moves of a saved plan against the loaded nodes.
+func (rt *RedisTrib) PlanFromFile(pf *PlanFile) (*ReshardPlan, error) {
+	plan := &ReshardPlan{}
+	for _, m := range pf.Moves {
+		source := rt.GetNodeByName(m.Source)
+		target := rt.GetNodeByName(m.Target)
+		if source == nil || target == nil {
+			return nil, fmt.Errorf("unknown node in move from %s to %s", m.Source, m.Target)
+		}
+
+		slots, err := ParseNumRange(m.Slots, ClusterHashSlots)
+		if err != nil {
+			return nil, err
+		}
+		if len(slots) != m.NumSlots {
+			return nil, fmt.Errorf("move from %s to %s lists %d slots, expected %d",
+				m.Source, m.Target, len(slots), m.NumSlots)
+		}
+		for _, slot := range slots {
+			if _, ok := source.Slots()[slot]; !ok {
+				return nil, fmt.Errorf("slot %d is not served by %s", slot, source.String())
+			}
+		}
+		plan.Add(source, target, slots)
+	}
+	return plan, nil
+}
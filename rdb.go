@@ -0,0 +1,1248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+)
+
+// RDB opcodes and value types, see rdb.h in the Redis sources.
+const (
+	rdbOpcodeSlotInfo     = 0xF4
+	rdbOpcodeFunction2    = 0xF5
+	rdbOpcodeFunction     = 0xF6
+	rdbOpcodeModuleAux    = 0xF7
+	rdbOpcodeIdle         = 0xF8
+	rdbOpcodeFreq         = 0xF9
+	rdbOpcodeAux          = 0xFA
+	rdbOpcodeResizeDB     = 0xFB
+	rdbOpcodeExpireTimeMs = 0xFC
+	rdbOpcodeExpireTime   = 0xFD
+	rdbOpcodeSelectDB     = 0xFE
+	rdbOpcodeEOF          = 0xFF
+
+	rdbTypeString            = 0
+	rdbTypeList              = 1
+	rdbTypeSet               = 2
+	rdbTypeZset              = 3
+	rdbTypeHash              = 4
+	rdbTypeZset2             = 5
+	rdbTypeModule            = 6
+	rdbTypeModule2           = 7
+	rdbTypeHashZipmap        = 9
+	rdbTypeListZiplist       = 10
+	rdbTypeSetIntset         = 11
+	rdbTypeZsetZiplist       = 12
+	rdbTypeHashZiplist       = 13
+	rdbTypeListQuicklist     = 14
+	rdbTypeStreamListpacks   = 15
+	rdbTypeHashListpack      = 16
+	rdbTypeZsetListpack      = 17
+	rdbTypeListQuicklist2    = 18
+	rdbTypeStreamListpacks2  = 19
+	rdbTypeSetListpack       = 20
+	rdbTypeStreamListpacks3  = 21
+	rdbEncInt8               = 0
+	rdbEncInt16              = 1
+	rdbEncInt32              = 2
+	rdbEncLZF                = 3
+	rdbModuleOpcodeEOF       = 0
+	rdbModuleOpcodeSint      = 1
+	rdbModuleOpcodeUint      = 2
+	rdbModuleOpcodeFloat     = 3
+	rdbModuleOpcodeDouble    = 4
+	rdbModuleOpcodeString    = 5
+	rdbQuicklistNodePlain    = 1
+	rdbStreamItemFlagDeleted = 1
+	rdbStreamItemFlagSame    = 2
+
+	// Newest RDB version this parser knows about.
+	RdbMaxVersion = 12
+)
+
+// The Redis flavor of CRC64 (Jones polynomial, reflected, no final
+// xor), used by the RDB files and the DUMP payloads.
+var crc64Table = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+func rdbCRC64(crc uint64, data []byte) uint64 {
+	return ^crc64.Update(^crc, crc64Table, data)
+}
+
+// A key read from an RDB file. Raw is the serialized value without
+// the type byte, as expected by RESTORE once wrapped with Payload.
+type RdbEntry struct {
+	DB       int
+	Key      string
+	Type     byte
+	ExpireAt int64 // unix time in milliseconds, 0 without expire
+	Raw      []byte
+	Version  uint16
+}
+
+// Return the key type as named by the TYPE command.
+func (e *RdbEntry) TypeName() string {
+	return RdbTypeName(e.Type)
+}
+
+func RdbTypeName(t byte) string {
+	switch t {
+	case rdbTypeString:
+		return "string"
+	case rdbTypeList, rdbTypeListZiplist, rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		return "list"
+	case rdbTypeSet, rdbTypeSetIntset, rdbTypeSetListpack:
+		return "set"
+	case rdbTypeZset, rdbTypeZset2, rdbTypeZsetZiplist, rdbTypeZsetListpack:
+		return "zset"
+	case rdbTypeHash, rdbTypeHashZipmap, rdbTypeHashZiplist, rdbTypeHashListpack:
+		return "hash"
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return "stream"
+	case rdbTypeModule, rdbTypeModule2:
+		return "module"
+	}
+	return "unknown"
+}
+
+// Return the value in the DUMP format: type, value, RDB version and
+// CRC64 of all of it, both little endian.
+func (e *RdbEntry) Payload() []byte {
+	payload := make([]byte, 0, len(e.Raw)+11)
+	payload = append(payload, e.Type)
+	payload = append(payload, e.Raw...)
+	payload = append(payload, byte(e.Version), byte(e.Version>>8))
+	crc := rdbCRC64(0, payload)
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], crc)
+	return append(payload, sum[:]...)
+}
+
+// Decode the value: a string, a []string for lists and sets, a
+// []ZsetMember, a map[string]string for hashes or a []StreamEntry.
+func (e *RdbEntry) Value() (interface{}, error) {
+	rd := &rdbReader{r: bufio.NewReader(bytes.NewReader(e.Raw)), version: int(e.Version)}
+	return rd.readValue(e.Type)
+}
+
+type ZsetMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// Encode the member to JSON, with the scores JSON has no number for as
+// "inf", "-inf" and "nan", the way redis writes them.
+func (m ZsetMember) MarshalJSON() ([]byte, error) {
+	var score interface{} = m.Score
+	switch {
+	case math.IsNaN(m.Score):
+		score = "nan"
+	case math.IsInf(m.Score, 1):
+		score = "inf"
+	case math.IsInf(m.Score, -1):
+		score = "-inf"
+	}
+	return json.Marshal(&struct {
+		Member string      `json:"member"`
+		Score  interface{} `json:"score"`
+	}{m.Member, score})
+}
+
+type StreamEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+// Parse a DUMP payload back into an entry, checking its checksum.
+func ParseDumpPayload(key string, payload []byte) (*RdbEntry, error) {
+	if len(payload) < 11 {
+		return nil, errors.New("DUMP payload too short")
+	}
+	body := payload[:len(payload)-8]
+	if crc := binary.LittleEndian.Uint64(payload[len(payload)-8:]); crc != 0 && crc != rdbCRC64(0, body) {
+		return nil, errors.New("DUMP payload checksum mismatch")
+	}
+	return &RdbEntry{
+		Key:     key,
+		Type:    body[0],
+		Raw:     body[1 : len(body)-2],
+		Version: binary.LittleEndian.Uint16(body[len(body)-2:]),
+	}, nil
+}
+
+// Read the keys of an RDB file one by one.
+type RdbReader struct {
+	rd       *rdbReader
+	db       int
+	checksum bool
+}
+
+func NewRdbReader(r io.Reader) (*RdbReader, error) {
+	rd := &rdbReader{r: bufio.NewReaderSize(r, 1<<16), crc: true}
+	header := make([]byte, 9)
+	if err := rd.readFull(header); err != nil {
+		return nil, err
+	}
+	if string(header[:5]) != "REDIS" {
+		return nil, errors.New("not an RDB file")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RDB version %q", header[5:])
+	}
+	if version < 1 || version > RdbMaxVersion {
+		return nil, fmt.Errorf("unsupported RDB version %d", version)
+	}
+	rd.version = version
+	return &RdbReader{rd: rd, checksum: version >= 5}, nil
+}
+
+func (r *RdbReader) Version() int {
+	return r.rd.version
+}
+
+// Return the next key, or io.EOF after the last one.
+func (r *RdbReader) Next() (*RdbEntry, error) {
+	rd := r.rd
+	var expireAt int64
+	for {
+		t, err := rd.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case rdbOpcodeEOF:
+			if r.checksum {
+				expected := rd.sum
+				var sum [8]byte
+				if err := rd.readFull(sum[:]); err != nil {
+					return nil, err
+				}
+				if crc := binary.LittleEndian.Uint64(sum[:]); crc != 0 && crc != expected {
+					return nil, errors.New("RDB checksum mismatch")
+				}
+			}
+			return nil, io.EOF
+		case rdbOpcodeSelectDB:
+			db, err := rd.readLength()
+			if err != nil {
+				return nil, err
+			}
+			r.db = int(db)
+		case rdbOpcodeResizeDB:
+			if _, err := rd.readLength(); err != nil {
+				return nil, err
+			}
+			if _, err := rd.readLength(); err != nil {
+				return nil, err
+			}
+		case rdbOpcodeSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, err := rd.readLength(); err != nil {
+					return nil, err
+				}
+			}
+		case rdbOpcodeAux:
+			if _, err := rd.readString(); err != nil {
+				return nil, err
+			}
+			if _, err := rd.readString(); err != nil {
+				return nil, err
+			}
+		case rdbOpcodeFunction, rdbOpcodeFunction2:
+			// Function libraries are not keys, and can't be restored
+			// with RESTORE.
+			if _, err := rd.readString(); err != nil {
+				return nil, err
+			}
+		case rdbOpcodeModuleAux:
+			if _, err := rd.readLength(); err != nil {
+				return nil, err
+			}
+			if err := rd.skipModuleValue(); err != nil {
+				return nil, err
+			}
+		case rdbOpcodeIdle:
+			if _, err := rd.readLength(); err != nil {
+				return nil, err
+			}
+		case rdbOpcodeFreq:
+			if _, err := rd.readByte(); err != nil {
+				return nil, err
+			}
+		case rdbOpcodeExpireTime:
+			var buf [4]byte
+			if err := rd.readFull(buf[:]); err != nil {
+				return nil, err
+			}
+			expireAt = int64(binary.LittleEndian.Uint32(buf[:])) * 1000
+		case rdbOpcodeExpireTimeMs:
+			var buf [8]byte
+			if err := rd.readFull(buf[:]); err != nil {
+				return nil, err
+			}
+			expireAt = int64(binary.LittleEndian.Uint64(buf[:]))
+		default:
+			key, err := rd.readString()
+			if err != nil {
+				return nil, err
+			}
+
+			// Record the bytes of the value while walking it.
+			rd.rec = &bytes.Buffer{}
+			_, err = rd.readValue(t)
+			raw := rd.rec.Bytes()
+			rd.rec = nil
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %s", key, err.Error())
+			}
+
+			return &RdbEntry{
+				DB:       r.db,
+				Key:      key,
+				Type:     t,
+				ExpireAt: expireAt,
+				Raw:      raw,
+				Version:  uint16(rd.version),
+			}, nil
+		}
+	}
+}
+
+// Write keys to an RDB file, all in the database 0. The values are
+// written as they were serialized by DUMP, so the version of the file
+// must be at least the one of every payload.
+type RdbWriter struct {
+	w       *bufio.Writer
+	version int
+	sum     uint64
+}
+
+func NewRdbWriter(w io.Writer, version int) (*RdbWriter, error) {
+	if version < 1 || version > RdbMaxVersion {
+		return nil, fmt.Errorf("unsupported RDB version %d", version)
+	}
+	wr := &RdbWriter{w: bufio.NewWriterSize(w, 1<<16), version: version}
+	if err := wr.write([]byte(fmt.Sprintf("REDIS%04d", version))); err != nil {
+		return nil, err
+	}
+	if err := wr.write([]byte{rdbOpcodeSelectDB, 0}); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+func (wr *RdbWriter) Version() int {
+	return wr.version
+}
+
+func (wr *RdbWriter) write(p []byte) error {
+	wr.sum = rdbCRC64(wr.sum, p)
+	_, err := wr.w.Write(p)
+	return err
+}
+
+// Encode a length the way rdbSaveLen does.
+func rdbLength(n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return []byte{byte(n)}
+	case n < 1<<14:
+		return []byte{byte(n>>8) | 0x40, byte(n)}
+	case n <= math.MaxUint32:
+		buf := []byte{0x80, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		return buf
+	}
+	buf := []byte{0x81, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(buf[1:], n)
+	return buf
+}
+
+func (wr *RdbWriter) Write(e *RdbEntry) error {
+	if int(e.Version) > wr.version {
+		return fmt.Errorf("key %q has RDB version %d, newer than the file version %d", e.Key, e.Version, wr.version)
+	}
+
+	buf := make([]byte, 0, len(e.Key)+len(e.Raw)+20)
+	if e.ExpireAt > 0 {
+		var expire [8]byte
+		binary.LittleEndian.PutUint64(expire[:], uint64(e.ExpireAt))
+		buf = append(buf, rdbOpcodeExpireTimeMs)
+		buf = append(buf, expire[:]...)
+	}
+	buf = append(buf, e.Type)
+	buf = append(buf, rdbLength(uint64(len(e.Key)))...)
+	buf = append(buf, e.Key...)
+	buf = append(buf, e.Raw...)
+	return wr.write(buf)
+}
+
+// Write the end of file opcode and the checksum.
+func (wr *RdbWriter) Close() error {
+	if err := wr.write([]byte{rdbOpcodeEOF}); err != nil {
+		return err
+	}
+	if wr.version >= 5 {
+		var sum [8]byte
+		binary.LittleEndian.PutUint64(sum[:], wr.sum)
+		if _, err := wr.w.Write(sum[:]); err != nil {
+			return err
+		}
+	}
+	return wr.w.Flush()
+}
+
+// Low level reader of the RDB encoding. It can record the bytes read,
+// and compute their CRC64.
+type rdbReader struct {
+	r       *bufio.Reader
+	version int
+	rec     *bytes.Buffer
+	crc     bool
+	sum     uint64
+}
+
+func (rd *rdbReader) consumed(p []byte) {
+	if rd.rec != nil {
+		rd.rec.Write(p)
+	}
+	if rd.crc {
+		rd.sum = rdbCRC64(rd.sum, p)
+	}
+}
+
+func (rd *rdbReader) readFull(p []byte) error {
+	if _, err := io.ReadFull(rd.r, p); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	rd.consumed(p)
+	return nil
+}
+
+func (rd *rdbReader) readByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(rd.r, b[:]); err != nil {
+		return 0, err
+	}
+	rd.consumed(b[:])
+	return b[0], nil
+}
+
+// Read a length, or the special encoding of a string when encoded is
+// true.
+func (rd *rdbReader) readLengthEncoded() (length uint64, encoded bool, err error) {
+	b, err := rd.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := rd.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			var buf [4]byte
+			err := rd.readFull(buf[:])
+			return uint64(binary.BigEndian.Uint32(buf[:])), false, err
+		case 0x81:
+			var buf [8]byte
+			err := rd.readFull(buf[:])
+			return binary.BigEndian.Uint64(buf[:]), false, err
+		}
+		return 0, false, fmt.Errorf("unknown length encoding 0x%02x", b)
+	}
+	return uint64(b & 0x3f), true, nil
+}
+
+func (rd *rdbReader) readLength() (uint64, error) {
+	length, encoded, err := rd.readLengthEncoded()
+	if err == nil && encoded {
+		err = errors.New("unexpected string encoding in place of a length")
+	}
+	return length, err
+}
+
+func (rd *rdbReader) readBytes(n uint64) ([]byte, error) {
+	if n > math.MaxInt32 {
+		return nil, fmt.Errorf("invalid string length %d", n)
+	}
+	buf := make([]byte, n)
+	return buf, rd.readFull(buf)
+}
+
+func (rd *rdbReader) readString() (string, error) {
+	length, encoded, err := rd.readLengthEncoded()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		buf, err := rd.readBytes(length)
+		return string(buf), err
+	}
+
+	switch length {
+	case rdbEncInt8:
+		b, err := rd.readByte()
+		return strconv.Itoa(int(int8(b))), err
+	case rdbEncInt16:
+		var buf [2]byte
+		err := rd.readFull(buf[:])
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf[:])))), err
+	case rdbEncInt32:
+		var buf [4]byte
+		err := rd.readFull(buf[:])
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf[:])))), err
+	case rdbEncLZF:
+		clen, err := rd.readLength()
+		if err != nil {
+			return "", err
+		}
+		ulen, err := rd.readLength()
+		if err != nil {
+			return "", err
+		}
+		compressed, err := rd.readBytes(clen)
+		if err != nil {
+			return "", err
+		}
+		out, err := lzfDecompress(compressed, int(ulen))
+		return string(out), err
+	}
+	return "", fmt.Errorf("unknown string encoding %d", length)
+}
+
+// Read a double of the old zset encoding: a length byte followed by
+// the number as text.
+func (rd *rdbReader) readDouble() (float64, error) {
+	length, err := rd.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := rd.readBytes(uint64(length))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+func (rd *rdbReader) readBinaryDouble() (float64, error) {
+	var buf [8]byte
+	if err := rd.readFull(buf[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+func (rd *rdbReader) readStrings(n uint64) ([]string, error) {
+	var values []string
+	for i := uint64(0); i < n; i++ {
+		s, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, s)
+	}
+	return values, nil
+}
+
+func (rd *rdbReader) readValue(t byte) (interface{}, error) {
+	switch t {
+	case rdbTypeString:
+		return rd.readString()
+
+	case rdbTypeList, rdbTypeSet:
+		n, err := rd.readLength()
+		if err != nil {
+			return nil, err
+		}
+		return rd.readStrings(n)
+
+	case rdbTypeZset, rdbTypeZset2:
+		n, err := rd.readLength()
+		if err != nil {
+			return nil, err
+		}
+		var members []ZsetMember
+		for i := uint64(0); i < n; i++ {
+			member, err := rd.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if t == rdbTypeZset2 {
+				score, err = rd.readBinaryDouble()
+			} else {
+				score, err = rd.readDouble()
+			}
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, ZsetMember{Member: member, Score: score})
+		}
+		return members, nil
+
+	case rdbTypeHash:
+		n, err := rd.readLength()
+		if err != nil {
+			return nil, err
+		}
+		values, err := rd.readStrings(n * 2)
+		if err != nil {
+			return nil, err
+		}
+		return pairsToHash(values), nil
+
+	case rdbTypeModule:
+		return nil, errors.New("modules values of RDB version 8 are not supported")
+
+	case rdbTypeModule2:
+		if _, err := rd.readLength(); err != nil {
+			return nil, err
+		}
+		return nil, rd.skipModuleValue()
+
+	case rdbTypeHashZipmap:
+		blob, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		values, err := decodeZipmap([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		return pairsToHash(values), nil
+
+	case rdbTypeListZiplist:
+		blob, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		return decodeZiplist([]byte(blob))
+
+	case rdbTypeSetIntset:
+		blob, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		return decodeIntset([]byte(blob))
+
+	case rdbTypeZsetZiplist, rdbTypeZsetListpack, rdbTypeHashZiplist, rdbTypeHashListpack:
+		blob, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		var values []string
+		if t == rdbTypeZsetZiplist || t == rdbTypeHashZiplist {
+			values, err = decodeZiplist([]byte(blob))
+		} else {
+			values, err = decodeListpack([]byte(blob))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t == rdbTypeHashZiplist || t == rdbTypeHashListpack {
+			return pairsToHash(values), nil
+		}
+		return pairsToZset(values)
+
+	case rdbTypeSetListpack:
+		blob, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		return decodeListpack([]byte(blob))
+
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		n, err := rd.readLength()
+		if err != nil {
+			return nil, err
+		}
+		var values []string
+		for i := uint64(0); i < n; i++ {
+			container := uint64(0)
+			if t == rdbTypeListQuicklist2 {
+				if container, err = rd.readLength(); err != nil {
+					return nil, err
+				}
+			}
+			blob, err := rd.readString()
+			if err != nil {
+				return nil, err
+			}
+
+			var node []string
+			switch {
+			case container == rdbQuicklistNodePlain:
+				node = []string{blob}
+			case t == rdbTypeListQuicklist:
+				node, err = decodeZiplist([]byte(blob))
+			default:
+				node, err = decodeListpack([]byte(blob))
+			}
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, node...)
+		}
+		return values, nil
+
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return rd.readStream(t)
+	}
+	return nil, fmt.Errorf("unknown value type %d", t)
+}
+
+// Read a stream, only its entries are returned, the consumer groups
+// are walked for the bytes of the value to be complete.
+func (rd *rdbReader) readStream(t byte) ([]StreamEntry, error) {
+	n, err := rd.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []StreamEntry
+	for i := uint64(0); i < n; i++ {
+		master, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		blob, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(master) != 16 {
+			return nil, errors.New("invalid stream node key")
+		}
+		items, err := decodeListpack([]byte(blob))
+		if err != nil {
+			return nil, err
+		}
+		node, err := decodeStreamNode([]byte(master), items)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, node...)
+	}
+
+	// Length, last id, and for newer types first id, max deleted id
+	// and entries added.
+	lengths := 3
+	if t >= rdbTypeStreamListpacks2 {
+		lengths += 5
+	}
+	for i := 0; i < lengths; i++ {
+		if _, err := rd.readLength(); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := rd.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if _, err := rd.readString(); err != nil {
+			return nil, err
+		}
+		lengths := 2
+		if t >= rdbTypeStreamListpacks2 {
+			lengths++
+		}
+		for j := 0; j < lengths; j++ {
+			if _, err := rd.readLength(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Global PEL: id, delivery time and delivery count.
+		pel, err := rd.readLength()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < pel; j++ {
+			if _, err := rd.readBytes(16 + 8); err != nil {
+				return nil, err
+			}
+			if _, err := rd.readLength(); err != nil {
+				return nil, err
+			}
+		}
+
+		consumers, err := rd.readLength()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			if _, err := rd.readString(); err != nil {
+				return nil, err
+			}
+			times := uint64(8)
+			if t >= rdbTypeStreamListpacks3 {
+				times += 8
+			}
+			if _, err := rd.readBytes(times); err != nil {
+				return nil, err
+			}
+			pel, err := rd.readLength()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := rd.readBytes(pel * 16); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+// Skip a module value serialized with the opcodes of RDB version 9.
+func (rd *rdbReader) skipModuleValue() error {
+	for {
+		opcode, err := rd.readLength()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case rdbModuleOpcodeEOF:
+			return nil
+		case rdbModuleOpcodeSint, rdbModuleOpcodeUint:
+			_, err = rd.readLength()
+		case rdbModuleOpcodeFloat:
+			_, err = rd.readBytes(4)
+		case rdbModuleOpcodeDouble:
+			_, err = rd.readBytes(8)
+		case rdbModuleOpcodeString:
+			_, err = rd.readString()
+		default:
+			err = fmt.Errorf("unknown module opcode %d", opcode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func pairsToHash(values []string) map[string]string {
+	hash := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		hash[values[i]] = values[i+1]
+	}
+	return hash
+}
+
+func pairsToZset(values []string) ([]ZsetMember, error) {
+	var members []ZsetMember
+	for i := 0; i+1 < len(values); i += 2 {
+		score, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, ZsetMember{Member: values[i], Score: score})
+	}
+	return members, nil
+}
+
+var errRdbTruncated = errors.New("truncated encoded value")
+
+// Decode a ziplist: header, entries made of the previous entry length,
+// an encoding and the data, and a 0xFF terminator.
+func decodeZiplist(buf []byte) ([]string, error) {
+	if len(buf) < 11 {
+		return nil, errRdbTruncated
+	}
+	var values []string
+	pos := 10
+	for {
+		if pos >= len(buf) {
+			return nil, errRdbTruncated
+		}
+		if buf[pos] == 0xff {
+			return values, nil
+		}
+
+		// Previous entry length.
+		if buf[pos] == 0xfe {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(buf) {
+			return nil, errRdbTruncated
+		}
+
+		enc := buf[pos]
+		var value string
+		var length, size int
+		switch {
+		case enc>>6 == 0:
+			length, size = int(enc&0x3f), 1
+		case enc>>6 == 1:
+			if pos+1 >= len(buf) {
+				return nil, errRdbTruncated
+			}
+			length, size = int(enc&0x3f)<<8|int(buf[pos+1]), 2
+		case enc == 0x80:
+			if pos+5 > len(buf) {
+				return nil, errRdbTruncated
+			}
+			length, size = int(binary.BigEndian.Uint32(buf[pos+1:])), 5
+		default:
+			var n int64
+			var width int
+			switch enc {
+			case 0xc0:
+				width = 2
+			case 0xd0:
+				width = 4
+			case 0xe0:
+				width = 8
+			case 0xf0:
+				width = 3
+			case 0xfe:
+				width = 1
+			default:
+				if enc >= 0xf1 && enc <= 0xfd {
+					n = int64(enc&0x0f) - 1
+				} else {
+					return nil, fmt.Errorf("unknown ziplist encoding 0x%02x", enc)
+				}
+			}
+			if pos+1+width > len(buf) {
+				return nil, errRdbTruncated
+			}
+			if width > 0 {
+				n = readIntLE(buf[pos+1:pos+1+width], width)
+			}
+			values = append(values, strconv.FormatInt(n, 10))
+			pos += 1 + width
+			continue
+		}
+
+		start := pos + size
+		if start+length > len(buf) {
+			return nil, errRdbTruncated
+		}
+		value = string(buf[start : start+length])
+		values = append(values, value)
+		pos = start + length
+	}
+}
+
+// Decode a listpack: header, entries made of an encoding, the data and
+// the entry length backwards, and a 0xFF terminator.
+func decodeListpack(buf []byte) ([]string, error) {
+	if len(buf) < 7 {
+		return nil, errRdbTruncated
+	}
+	var values []string
+	pos := 6
+	for {
+		if pos >= len(buf) {
+			return nil, errRdbTruncated
+		}
+		enc := buf[pos]
+		if enc == 0xff {
+			return values, nil
+		}
+
+		var header, length int
+		isInt := false
+		var n int64
+		switch {
+		case enc&0x80 == 0:
+			header, isInt, n = 1, true, int64(enc&0x7f)
+		case enc&0xc0 == 0x80:
+			header, length = 1, int(enc&0x3f)
+		case enc&0xe0 == 0xc0:
+			if pos+1 >= len(buf) {
+				return nil, errRdbTruncated
+			}
+			header, isInt = 2, true
+			n = int64(enc&0x1f)<<8 | int64(buf[pos+1])
+			if n >= 1<<12 {
+				n -= 1 << 13
+			}
+		case enc&0xf0 == 0xe0:
+			if pos+1 >= len(buf) {
+				return nil, errRdbTruncated
+			}
+			header, length = 2, int(enc&0x0f)<<8|int(buf[pos+1])
+		case enc == 0xf0:
+			if pos+5 > len(buf) {
+				return nil, errRdbTruncated
+			}
+			header, length = 5, int(binary.LittleEndian.Uint32(buf[pos+1:]))
+		case enc >= 0xf1 && enc <= 0xf4:
+			width := map[byte]int{0xf1: 2, 0xf2: 3, 0xf3: 4, 0xf4: 8}[enc]
+			if pos+1+width > len(buf) {
+				return nil, errRdbTruncated
+			}
+			header, isInt = 1+width, true
+			n = readIntLE(buf[pos+1:pos+1+width], width)
+		default:
+			return nil, fmt.Errorf("unknown listpack encoding 0x%02x", enc)
+		}
+
+		entry := header
+		if isInt {
+			values = append(values, strconv.FormatInt(n, 10))
+		} else {
+			if pos+header+length > len(buf) {
+				return nil, errRdbTruncated
+			}
+			values = append(values, string(buf[pos+header:pos+header+length]))
+			entry += length
+		}
+		pos += entry + listpackBacklenSize(entry)
+	}
+}
+
+func listpackBacklenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// Read a little endian signed integer of 1, 2, 3, 4 or 8 bytes.
+func readIntLE(buf []byte, width int) int64 {
+	var u uint64
+	for i := width - 1; i >= 0; i-- {
+		u = u<<8 | uint64(buf[i])
+	}
+	shift := uint(64 - 8*width)
+	return int64(u<<shift) >> shift
+}
+
+func decodeIntset(buf []byte) ([]string, error) {
+	if len(buf) < 8 {
+		return nil, errRdbTruncated
+	}
+	width := int(binary.LittleEndian.Uint32(buf))
+	n := int(binary.LittleEndian.Uint32(buf[4:]))
+	if width != 2 && width != 4 && width != 8 {
+		return nil, fmt.Errorf("invalid intset encoding %d", width)
+	}
+	if 8+n*width > len(buf) {
+		return nil, errRdbTruncated
+	}
+
+	values := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		pos := 8 + i*width
+		values = append(values, strconv.FormatInt(readIntLE(buf[pos:pos+width], width), 10))
+	}
+	return values, nil
+}
+
+// Decode a zipmap: field and value lengths of 1 or 5 bytes, a free
+// space byte after the value length, and a 0xFF terminator.
+func decodeZipmap(buf []byte) ([]string, error) {
+	var values []string
+	pos := 1
+	readLen := func() (int, bool) {
+		if pos >= len(buf) || buf[pos] == 0xff {
+			return 0, false
+		}
+		if buf[pos] < 254 {
+			pos++
+			return int(buf[pos-1]), true
+		}
+		if pos+5 > len(buf) {
+			return 0, false
+		}
+		l := int(binary.LittleEndian.Uint32(buf[pos+1:]))
+		pos += 5
+		return l, true
+	}
+
+	for {
+		if pos >= len(buf) {
+			return nil, errRdbTruncated
+		}
+		if buf[pos] == 0xff {
+			return values, nil
+		}
+
+		klen, ok := readLen()
+		if !ok || pos+klen > len(buf) {
+			return nil, errRdbTruncated
+		}
+		field := string(buf[pos : pos+klen])
+		pos += klen
+
+		vlen, ok := readLen()
+		if !ok || pos >= len(buf) {
+			return nil, errRdbTruncated
+		}
+		free := int(buf[pos])
+		pos++
+		if pos+vlen+free > len(buf) {
+			return nil, errRdbTruncated
+		}
+		values = append(values, field, string(buf[pos:pos+vlen]))
+		pos += vlen + free
+	}
+}
+
+// Decode the entries of a stream listpack node. The node starts with
+// a master entry holding the fields shared by the entries flagged
+// with SAMEFIELDS, and every entry ends with its number of elements.
+func decodeStreamNode(master []byte, items []string) ([]StreamEntry, error) {
+	ms := binary.BigEndian.Uint64(master[:8])
+	seq := binary.BigEndian.Uint64(master[8:])
+
+	next := func() (int64, error) {
+		if len(items) == 0 {
+			return 0, errRdbTruncated
+		}
+		n, err := strconv.ParseInt(items[0], 10, 64)
+		items = items[1:]
+		return n, err
+	}
+
+	count, err := next()
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := next()
+	if err != nil {
+		return nil, err
+	}
+	numFields, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(items)) < numFields+1 {
+		return nil, errRdbTruncated
+	}
+	masterFields := items[:numFields]
+	items = items[numFields+1:]
+
+	var entries []StreamEntry
+	for i := int64(0); i < count+deleted; i++ {
+		flags, err := next()
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err := next()
+		if err != nil {
+			return nil, err
+		}
+		seqDiff, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		var fields []string
+		if flags&rdbStreamItemFlagSame != 0 {
+			if int64(len(items)) < numFields {
+				return nil, errRdbTruncated
+			}
+			for j, field := range masterFields {
+				fields = append(fields, field, items[j])
+			}
+			items = items[numFields:]
+		} else {
+			n, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(items)) < 2*n {
+				return nil, errRdbTruncated
+			}
+			fields = append(fields, items[:2*n]...)
+			items = items[2*n:]
+		}
+
+		// lp-count of the entry.
+		if _, err := next(); err != nil {
+			return nil, err
+		}
+		if flags&rdbStreamItemFlagDeleted != 0 {
+			continue
+		}
+		id := fmt.Sprintf("%d-%d", ms+uint64(msDiff), seq+uint64(seqDiff))
+		entries = append(entries, StreamEntry{ID: id, Fields: fields})
+	}
+	return entries, nil
+}
+
+// Decompress LZF data, as written by lzf_compress in the Redis sources.
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	out := make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 32 {
+			// Literal run of ctrl+1 bytes.
+			ctrl++
+			if i+ctrl > len(in) {
+				return nil, errRdbTruncated
+			}
+			out = append(out, in[i:i+ctrl]...)
+			i += ctrl
+			continue
+		}
+
+		// Back reference.
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errRdbTruncated
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errRdbTruncated
+		}
+		ref := len(out) - ((ctrl&0x1f)<<8 | int(in[i])) - 1
+		i++
+		if ref < 0 {
+			return nil, errors.New("invalid LZF back reference")
+		}
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != length {
+		return nil, fmt.Errorf("LZF decompressed %d bytes instead of %d", len(out), length)
+	}
+	return out, nil
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Builders of the encodings, laid out the way Redis writes them.
+
+func rdbStr(s string) []byte {
+	return append(rdbLength(uint64(len(s))), s...)
+}
+
+func le16(v uint16) []byte {
+	buf := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf, v)
+	return buf
+}
+
+func le32(v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, v)
+	return buf
+}
+
+func le64(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, v)
+	return buf
+}
+
+func concat(parts ...[]byte) []byte {
+	var buf []byte
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// A ziplist string entry, without its previous entry length.
+func zlStr(s string) []byte {
+	switch {
+	case len(s) < 1<<6:
+		return append([]byte{byte(len(s))}, s...)
+	case len(s) < 1<<14:
+		return append([]byte{0x40 | byte(len(s)>>8), byte(len(s))}, s...)
+	}
+	buf := []byte{0x80, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(s)))
+	return append(buf, s...)
+}
+
+// A ziplist of the entries, with the previous entry lengths.
+func ziplist(entries ...[]byte) []byte {
+	var body []byte
+	prev, tail := 0, 10
+	for _, e := range entries {
+		tail = 10 + len(body)
+		if prev < 254 {
+			body = append(body, byte(prev))
+		} else {
+			body = append(append(body, 0xfe), le32(uint32(prev))...)
+		}
+		body = append(body, e...)
+		prev = 10 + len(body) - tail
+	}
+	total := 10 + len(body) + 1
+	return concat(le32(uint32(total)), le32(uint32(tail)), le16(uint16(len(entries))), body, []byte{0xff})
+}
+
+func lpBacklen(l int) []byte {
+	switch {
+	case l <= 127:
+		return []byte{byte(l)}
+	case l < 16383:
+		return []byte{byte(l >> 7), byte(l&127) | 128}
+	}
+	return []byte{byte(l >> 14), byte(l>>7)&127 | 128, byte(l&127) | 128}
+}
+
+func lpEntry(enc []byte) []byte {
+	return append(enc, lpBacklen(len(enc))...)
+}
+
+func lpStr(s string) []byte {
+	switch {
+	case len(s) < 1<<6:
+		return lpEntry(append([]byte{0x80 | byte(len(s))}, s...))
+	case len(s) < 1<<12:
+		return lpEntry(append([]byte{0xe0 | byte(len(s)>>8), byte(len(s))}, s...))
+	}
+	return lpEntry(concat([]byte{0xf0}, le32(uint32(len(s))), []byte(s)))
+}
+
+func lpInt(v int64) []byte {
+	switch {
+	case v >= 0 && v <= 127:
+		return lpEntry([]byte{byte(v)})
+	case v >= -4096 && v < 4096:
+		u := uint16(v) & 0x1fff
+		return lpEntry([]byte{0xc0 | byte(u>>8), byte(u)})
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return lpEntry(append([]byte{0xf1}, le16(uint16(v))...))
+	case v >= -1<<23 && v < 1<<23:
+		return lpEntry(append([]byte{0xf2}, le32(uint32(v))[:3]...))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return lpEntry(append([]byte{0xf3}, le32(uint32(v))...))
+	}
+	return lpEntry(append([]byte{0xf4}, le64(uint64(v))...))
+}
+
+func listpack(entries ...[]byte) []byte {
+	body := concat(entries...)
+	total := 6 + len(body) + 1
+	return concat(le32(uint32(total)), le16(uint16(len(entries))), body, []byte{0xff})
+}
+
+func newBufReader(data []byte) *bufio.Reader {
+	return bufio.NewReader(bytes.NewReader(data))
+}
+
+func TestRdbCRC64(t *testing.T) {
+	// The check value of crc64.c in the Redis sources.
+	if got := rdbCRC64(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("rdbCRC64(123456789) = %#x, want 0xe9c6d914c4b8d9ca", got)
+	}
+	// Updates chain like a single pass.
+	if got := rdbCRC64(rdbCRC64(0, []byte("1234")), []byte("56789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("chained rdbCRC64(123456789) = %#x, want 0xe9c6d914c4b8d9ca", got)
+	}
+}
+
+func TestParseDumpPayload(t *testing.T) {
+	// DUMP of "SET mykey 10" on Redis 5, from the DUMP documentation.
+	payload := []byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n")
+	e, err := ParseDumpPayload("mykey", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != rdbTypeString || e.Version != 9 {
+		t.Errorf("type %d version %d, want 0 and 9", e.Type, e.Version)
+	}
+	value, err := e.Value()
+	if err != nil || value != "10" {
+		t.Errorf("value %v (%v), want 10", value, err)
+	}
+	if !bytes.Equal(e.Payload(), payload) {
+		t.Errorf("Payload() = %q, want %q", e.Payload(), payload)
+	}
+
+	corrupted := append([]byte{}, payload...)
+	corrupted[2] = '\x0b'
+	if _, err := ParseDumpPayload("mykey", corrupted); err == nil {
+		t.Errorf("corrupted payload accepted")
+	}
+	// A zero checksum is not checked, like in Redis.
+	copy(corrupted[len(corrupted)-8:], make([]byte, 8))
+	if _, err := ParseDumpPayload("mykey", corrupted); err != nil {
+		t.Errorf("payload without checksum: %s", err)
+	}
+	if _, err := ParseDumpPayload("mykey", payload[:10]); err == nil {
+		t.Errorf("truncated payload accepted")
+	}
+}
+
+func TestRdbLength(t *testing.T) {
+	for _, n := range []uint64{0, 63, 64, 16383, 16384, math.MaxUint32, math.MaxUint32 + 1} {
+		rd := &rdbReader{r: newBufReader(rdbLength(n))}
+		got, err := rd.readLength()
+		if err != nil || got != n {
+			t.Errorf("length %d read back as %d (%v)", n, got, err)
+		}
+	}
+}
+
+func TestLzfDecompress(t *testing.T) {
+	cases := []struct {
+		in   []byte
+		want string
+	}{
+		// Literal run.
+		{[]byte{2, 'a', 'b', 'c'}, "abc"},
+		// Short back reference: 3 bytes at distance 1.
+		{[]byte{0, 'a', 0x20, 0x00}, "aaaa"},
+		// Long back reference: 9 bytes at distance 3.
+		{[]byte{2, 'a', 'b', 'c', 0xe0, 0x00, 0x02}, "abcabcabcabc"},
+		// Literal after a back reference.
+		{[]byte{1, 'a', 'b', 0x20, 0x01, 0, 'z'}, "ababaz"},
+	}
+	for _, c := range cases {
+		got, err := lzfDecompress(c.in, len(c.want))
+		if err != nil || string(got) != c.want {
+			t.Errorf("lzfDecompress(%v) = %q (%v), want %q", c.in, got, err, c.want)
+		}
+	}
+
+	for _, in := range [][]byte{{5, 'a'}, {0x20, 0x00}, {0, 'a', 0x20}, {0, 'a', 0xe0}} {
+		if _, err := lzfDecompress(in, 4); err == nil {
+			t.Errorf("lzfDecompress(%v) succeeded, want an error", in)
+		}
+	}
+	if _, err := lzfDecompress([]byte{2, 'a', 'b', 'c'}, 4); err == nil {
+		t.Errorf("lzfDecompress with a wrong length succeeded")
+	}
+}
+
+func TestRdbValues(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	stream := streamValue(rdbTypeStreamListpacks3)
+
+	cases := []struct {
+		name string
+		t    byte
+		raw  []byte
+		want interface{}
+	}{
+		{"string", rdbTypeString, rdbStr("hello"), "hello"},
+		{"int8 string", rdbTypeString, []byte{0xc0, 0xf6}, "-10"},
+		{"int16 string", rdbTypeString, []byte{0xc1, 0xe8, 0x03}, "1000"},
+		{"int32 string", rdbTypeString, []byte{0xc2, 0xa0, 0x86, 0x01, 0x00}, "100000"},
+		{"lzf string", rdbTypeString, []byte{0xc3, 0x07, 0x0c, 2, 'a', 'b', 'c', 0xe0, 0x00, 0x02}, "abcabcabcabc"},
+		{"long string", rdbTypeString, rdbStr(long), long},
+
+		{"list", rdbTypeList, concat(rdbLength(2), rdbStr("a"), rdbStr("b")), []string{"a", "b"}},
+		{"set", rdbTypeSet, concat(rdbLength(2), rdbStr("x"), []byte{0xc0, 0x07}), []string{"x", "7"}},
+		{"hash", rdbTypeHash, concat(rdbLength(1), rdbStr("f"), rdbStr("v")), map[string]string{"f": "v"}},
+		{"zset", rdbTypeZset, concat(rdbLength(2), rdbStr("a"), []byte{3}, []byte("1.5"), rdbStr("b"), []byte{255}),
+			[]ZsetMember{{"a", 1.5}, {"b", math.Inf(-1)}}},
+		{"zset2", rdbTypeZset2, concat(rdbLength(1), rdbStr("a"), le64(math.Float64bits(-2.25))),
+			[]ZsetMember{{"a", -2.25}}},
+
+		{"list ziplist", rdbTypeListZiplist, rdbStr(string(ziplist(
+			zlStr("a"),
+			[]byte{0xf6},                   // 4 bit immediate 5
+			[]byte{0xfe, 0x80},             // int8
+			[]byte{0xc0, 0xfe, 0xff},       // int16
+			[]byte{0xf0, 0x56, 0x34, 0x12}, // int24
+			[]byte{0xd0, 0x00, 0x00, 0x01, 0x00},
+			concat([]byte{0xe0}, le64(1<<40)),
+			zlStr(long), // 14 bit length, next entry with a 5 bytes prevlen
+			zlStr("end"),
+			[]byte{0x80, 0, 0, 0, 2, 'h', 'i'}, // 32 bit length
+		))), []string{"a", "5", "-128", "-2", "1193046", "65536", "1099511627776", long, "end", "hi"}},
+
+		{"set intset16", rdbTypeSetIntset, rdbStr(string(concat(le32(2), le32(3), le16(1), le16(2), le16(0xffff)))),
+			[]string{"1", "2", "-1"}},
+		{"set intset64", rdbTypeSetIntset, rdbStr(string(concat(le32(8), le32(1), le64(1<<62)))),
+			[]string{"4611686018427387904"}},
+		{"set listpack", rdbTypeSetListpack, rdbStr(string(listpack(lpStr("m"), lpInt(42)))), []string{"m", "42"}},
+
+		{"hash zipmap", rdbTypeHashZipmap, rdbStr(string(concat(
+			[]byte{2},
+			[]byte{3}, []byte("foo"), []byte{3, 0}, []byte("bar"),
+			[]byte{1, 'a'}, []byte{1, 2}, []byte{'b', 0, 0},
+			[]byte{0xff},
+		))), map[string]string{"foo": "bar", "a": "b"}},
+		{"hash ziplist", rdbTypeHashZiplist, rdbStr(string(ziplist(zlStr("f"), zlStr("v"), zlStr("n"), []byte{0xf2}))),
+			map[string]string{"f": "v", "n": "1"}},
+		{"hash listpack", rdbTypeHashListpack, rdbStr(string(listpack(lpStr("f"), lpStr("v"), lpStr("n"), lpInt(-100)))),
+			map[string]string{"f": "v", "n": "-100"}},
+
+		{"zset ziplist", rdbTypeZsetZiplist, rdbStr(string(ziplist(zlStr("a"), []byte{0xf2}, zlStr("b"), zlStr("2.5")))),
+			[]ZsetMember{{"a", 1}, {"b", 2.5}}},
+		{"zset listpack", rdbTypeZsetListpack, rdbStr(string(listpack(lpStr("a"), lpInt(3), lpStr("b"), lpStr("inf")))),
+			[]ZsetMember{{"a", 3}, {"b", math.Inf(1)}}},
+
+		{"quicklist", rdbTypeListQuicklist, concat(rdbLength(2),
+			rdbStr(string(ziplist(zlStr("a"), zlStr("b")))),
+			rdbStr(string(ziplist([]byte{0xf3})))),
+			[]string{"a", "b", "2"}},
+		{"quicklist2", rdbTypeListQuicklist2, concat(rdbLength(2),
+			rdbLength(2), rdbStr(string(listpack(lpStr("a"), lpInt(1000), lpInt(-5000), lpInt(100000), lpInt(-5000000), lpInt(1<<30), lpInt(1<<40)))),
+			rdbLength(rdbQuicklistNodePlain), rdbStr(long)),
+			[]string{"a", "1000", "-5000", "100000", "-5000000", "1073741824", "1099511627776", long}},
+		{"listpack long strings", rdbTypeSetListpack, rdbStr(string(listpack(lpStr(long), lpStr(strings.Repeat("y", 5000))))),
+			[]string{long, strings.Repeat("y", 5000)}},
+
+		{"stream", rdbTypeStreamListpacks3, stream, []StreamEntry{
+			{ID: "1000-0", Fields: []string{"f", "v1"}},
+			{ID: "1002-5", Fields: []string{"a", "1", "b", "2"}},
+		}},
+	}
+
+	for _, c := range cases {
+		e := &RdbEntry{Key: c.name, Type: c.t, Raw: c.raw, Version: RdbMaxVersion}
+		got, err := e.Value()
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %#v, want %#v", c.name, got, c.want)
+		}
+
+		// The raw value is all the reader consumes.
+		rd := &rdbReader{r: newBufReader(concat(c.raw, []byte{0xff})), version: RdbMaxVersion, rec: &bytes.Buffer{}}
+		if _, err := rd.readValue(c.t); err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		} else if !bytes.Equal(rd.rec.Bytes(), c.raw) {
+			t.Errorf("%s: read %d bytes of %d", c.name, rd.rec.Len(), len(c.raw))
+		}
+	}
+}
+
+func TestRdbSpecialDoubles(t *testing.T) {
+	raw := concat(rdbLength(3), rdbStr("n"), []byte{253}, rdbStr("p"), []byte{254}, rdbStr("m"), []byte{255})
+	value, err := (&RdbEntry{Type: rdbTypeZset, Raw: raw}).Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	members := value.([]ZsetMember)
+	if len(members) != 3 || !math.IsNaN(members[0].Score) || !math.IsInf(members[1].Score, 1) || !math.IsInf(members[2].Score, -1) {
+		t.Errorf("got %v, want NaN, +Inf and -Inf", members)
+	}
+}
+
+func TestZsetMemberJSON(t *testing.T) {
+	members := []ZsetMember{{"a", 1.5}, {"b", math.Inf(1)}, {"c", math.Inf(-1)}, {"d", math.NaN()}}
+	data, err := json.Marshal(members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"member":"a","score":1.5},{"member":"b","score":"inf"},{"member":"c","score":"-inf"},{"member":"d","score":"nan"}]`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
+func TestRdbTruncatedValues(t *testing.T) {
+	cases := []struct {
+		t   byte
+		raw []byte
+	}{
+		{rdbTypeListZiplist, rdbStr(string(ziplist(zlStr("abc"))[:12]))},
+		{rdbTypeSetListpack, rdbStr(string(listpack(lpStr("abc"))[:8]))},
+		{rdbTypeSetIntset, rdbStr(string(concat(le32(2), le32(3), le16(1))))},
+		{rdbTypeSetIntset, rdbStr(string(concat(le32(3), le32(1), le16(1))))},
+		{rdbTypeHashZipmap, rdbStr(string([]byte{1, 3, 'f'}))},
+		{rdbTypeListZiplist, rdbStr(string(ziplist([]byte{0xc5})))},
+		{rdbTypeString, []byte{0xc4}},
+		{42, []byte{0}},
+	}
+	for _, c := range cases {
+		e := &RdbEntry{Type: c.t, Raw: c.raw, Version: RdbMaxVersion}
+		if value, err := e.Value(); err == nil {
+			t.Errorf("type %d %v decoded as %v, want an error", c.t, c.raw, value)
+		}
+	}
+}
+
+// A stream with a listpack node holding an entry with the master
+// fields, a deleted entry and an entry with its own fields, one
+// consumer group with a pending entry and a consumer.
+func streamValue(t byte) []byte {
+	master := make([]byte, 16)
+	binary.BigEndian.PutUint64(master, 1000)
+	node := listpack(
+		lpInt(2), lpInt(1), lpInt(1), lpStr("f"), lpInt(0),
+		lpInt(rdbStreamItemFlagSame), lpInt(0), lpInt(0), lpStr("v1"), lpInt(4),
+		lpInt(rdbStreamItemFlagSame|rdbStreamItemFlagDeleted), lpInt(1), lpInt(0), lpStr("v2"), lpInt(4),
+		lpInt(0), lpInt(2), lpInt(5), lpInt(2), lpStr("a"), lpInt(1), lpStr("b"), lpInt(2), lpInt(8),
+	)
+	id := make([]byte, 16)
+	binary.BigEndian.PutUint64(id, 1002)
+	binary.BigEndian.PutUint64(id[8:], 5)
+
+	buf := concat(rdbLength(1), rdbStr(string(master)), rdbStr(string(node)))
+	// Length, last id, first id, max deleted id, entries added.
+	buf = concat(buf, rdbLength(2), rdbLength(1002), rdbLength(5))
+	if t >= rdbTypeStreamListpacks2 {
+		buf = concat(buf, rdbLength(1000), rdbLength(0), rdbLength(1001), rdbLength(0), rdbLength(3))
+	}
+	// A group, its last id and entries read.
+	buf = concat(buf, rdbLength(1), rdbStr("group"), rdbLength(1002), rdbLength(5))
+	if t >= rdbTypeStreamListpacks2 {
+		buf = concat(buf, rdbLength(2))
+	}
+	// The global PEL and a consumer.
+	buf = concat(buf, rdbLength(1), id, le64(1700000000000), rdbLength(1))
+	buf = concat(buf, rdbLength(1), rdbStr("alice"), le64(1700000000000))
+	if t >= rdbTypeStreamListpacks3 {
+		buf = concat(buf, le64(1700000000000))
+	}
+	return concat(buf, rdbLength(1), id)
+}
+
+func TestRdbStreamTypes(t *testing.T) {
+	want := []StreamEntry{
+		{ID: "1000-0", Fields: []string{"f", "v1"}},
+		{ID: "1002-5", Fields: []string{"a", "1", "b", "2"}},
+	}
+	for _, st := range []byte{rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3} {
+		raw := streamValue(st)
+		rd := &rdbReader{r: newBufReader(concat(raw, []byte{0xff})), version: RdbMaxVersion, rec: &bytes.Buffer{}}
+		got, err := rd.readValue(st)
+		if err != nil {
+			t.Errorf("type %d: %s", st, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("type %d: got %v, want %v", st, got, want)
+		}
+		if rd.rec.Len() != len(raw) {
+			t.Errorf("type %d: read %d bytes of %d", st, rd.rec.Len(), len(raw))
+		}
+	}
+}
+
+// A small RDB file with auxiliary fields, two databases, expires, LRU
+// and LFU info, slot info, module aux data and a function.
+func rdbFixture() []byte {
+	buf := concat([]byte("REDIS0011"),
+		[]byte{rdbOpcodeAux}, rdbStr("redis-ver"), rdbStr("7.2.4"),
+		[]byte{rdbOpcodeAux}, rdbStr("ctime"), []byte{0xc2}, le32(1700000000),
+		[]byte{rdbOpcodeFunction2}, rdbStr("#!lua name=lib\nredis.register_function('f', function() return 1 end)"),
+		[]byte{rdbOpcodeModuleAux}, rdbLength(12345),
+		rdbLength(rdbModuleOpcodeUint), rdbLength(2),
+		rdbLength(rdbModuleOpcodeString), rdbStr("aux"),
+		rdbLength(rdbModuleOpcodeDouble), le64(0),
+		rdbLength(rdbModuleOpcodeEOF),
+		[]byte{rdbOpcodeSelectDB}, rdbLength(0),
+		[]byte{rdbOpcodeResizeDB}, rdbLength(3), rdbLength(1),
+		[]byte{rdbOpcodeSlotInfo}, rdbLength(866), rdbLength(1), rdbLength(0),
+		[]byte{rdbTypeString}, rdbStr("plain"), rdbStr("value"),
+		[]byte{rdbOpcodeExpireTimeMs}, le64(1900000000123),
+		[]byte{rdbOpcodeIdle}, rdbLength(10),
+		[]byte{rdbTypeSetIntset}, rdbStr("ints"), rdbStr(string(concat(le32(2), le32(1), le16(7)))),
+		[]byte{rdbOpcodeExpireTime}, le32(1900000000),
+		[]byte{rdbOpcodeFreq}, []byte{3},
+		[]byte{rdbTypeListQuicklist2}, rdbStr("list"), rdbLength(1), rdbLength(2), rdbStr(string(listpack(lpStr("a")))),
+		[]byte{rdbOpcodeSelectDB}, rdbLength(1),
+		[]byte{rdbTypeHashListpack}, rdbStr("hash"), rdbStr(string(listpack(lpStr("f"), lpStr("v")))),
+		[]byte{rdbOpcodeEOF},
+	)
+	return append(buf, le64(rdbCRC64(0, buf))...)
+}
+
+func TestRdbReader(t *testing.T) {
+	want := []struct {
+		db       int
+		key      string
+		typ      string
+		expireAt int64
+	}{
+		{0, "plain", "string", 0},
+		{0, "ints", "set", 1900000000123},
+		{0, "list", "list", 1900000000000},
+		{1, "hash", "hash", 0},
+	}
+
+	data := rdbFixture()
+	r, err := NewRdbReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version() != 11 {
+		t.Errorf("version %d, want 11", r.Version())
+	}
+	for _, w := range want {
+		e, err := r.Next()
+		if err != nil {
+			t.Fatalf("%s: %s", w.key, err)
+		}
+		if e.DB != w.db || e.Key != w.key || e.TypeName() != w.typ || e.ExpireAt != w.expireAt {
+			t.Errorf("got db %d key %q type %s expire %d, want %+v", e.DB, e.Key, e.TypeName(), e.ExpireAt, w)
+		}
+		if _, err := e.Value(); err != nil {
+			t.Errorf("%s: %s", w.key, err)
+		}
+	}
+	if e, err := r.Next(); err != io.EOF {
+		t.Errorf("got %v (%v) after the last key, want EOF", e, err)
+	}
+
+	// A corrupted checksum is detected at the end of the file.
+	data[len(data)-1] ^= 0xff
+	r, err = NewRdbReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, err = r.Next()
+	}
+	if err == io.EOF {
+		t.Errorf("corrupted checksum not detected")
+	}
+
+	for _, header := range []string{"RDB0011xx", "REDIS00xx", "REDIS0099"} {
+		if _, err := NewRdbReader(strings.NewReader(header)); err == nil {
+			t.Errorf("header %q accepted", header)
+		}
+	}
+}
+
+func TestRdbWriterRoundTrip(t *testing.T) {
+	entries := []*RdbEntry{
+		{Key: "s", Type: rdbTypeString, Raw: rdbStr("value"), Version: 9},
+		{Key: "l", Type: rdbTypeListQuicklist2, Raw: concat(rdbLength(1), rdbLength(2), rdbStr(string(listpack(lpStr("a"), lpInt(1))))), Version: 11},
+		{Key: "h", Type: rdbTypeHashListpack, Raw: rdbStr(string(listpack(lpStr("f"), lpStr("v")))), Version: 10, ExpireAt: 1900000000123},
+		{Key: "x", Type: rdbTypeStreamListpacks3, Raw: streamValue(rdbTypeStreamListpacks3), Version: 11},
+		{Key: strings.Repeat("k", 100), Type: rdbTypeZset2, Raw: concat(rdbLength(1), rdbStr("m"), le64(math.Float64bits(math.Inf(1)))), Version: 11},
+	}
+
+	var buf bytes.Buffer
+	wr, err := NewRdbWriter(&buf, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		// Through DUMP payloads, the way export gets the values.
+		dumped, err := ParseDumpPayload(e.Key, e.Payload())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dumped.ExpireAt = e.ExpireAt
+		if err := wr.Write(dumped); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRdbReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("%s: %s", e.Key, err)
+		}
+		if got.Key != e.Key || got.Type != e.Type || got.ExpireAt != e.ExpireAt || !bytes.Equal(got.Raw, e.Raw) {
+			t.Errorf("%s: read back as %+v", e.Key, got)
+		}
+		want, _ := e.Value()
+		value, err := got.Value()
+		if err != nil || !reflect.DeepEqual(value, want) {
+			t.Errorf("%s: value %v (%v), want %v", e.Key, value, err, want)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("got %v after the last key, want EOF", err)
+	}
+
+	// The file version must cover the version of the values.
+	wr, _ = NewRdbWriter(&bytes.Buffer{}, 9)
+	if err := wr.Write(entries[1]); err == nil {
+		t.Errorf("entry of version 11 written to a file of version 9")
+	}
+	if _, err := NewRdbWriter(&bytes.Buffer{}, RdbMaxVersion+1); err == nil {
+		t.Errorf("writer of version %d created", RdbMaxVersion+1)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -15,9 +14,12 @@ import (
 //  rebalance       host:port
 //                  --weight <arg>
 //                  --auto-weights
+//                  --balance-by <arg>
+//                  --samples <arg>
 //                  --use-empty-masters
 //                  --timeout <arg>
 //                  --simulate
+//                  --plan-out <arg>
 //                  --pipeline <arg>
 //                  --threshold <arg>
 var rebalanceCommand = cli.Command{
@@ -33,7 +35,17 @@ var rebalanceCommand = cli.Command{
 		},
 		cli.BoolFlag{
 			Name:  "auto-weights",
-			Usage: `Auto-weights flag for rebalance cluster.`,
+			Usage: `Balance by the measured load of the slots instead of their number, same as --balance-by keys.`,
+		},
+		cli.StringFlag{
+			Name:  "balance-by",
+			Value: "slots",
+			Usage: `Load to balance across masters: slots, keys or memory.`,
+		},
+		cli.IntFlag{
+			Name:  "samples",
+			Value: RebalanceDefaultSamples,
+			Usage: `Keys sampled with MEMORY USAGE in every slot for --balance-by memory.`,
 		},
 		cli.BoolFlag{
 			Name:  "use-empty-masters",
@@ -57,6 +69,11 @@ var rebalanceCommand = cli.Command{
 			Value: RebalanceDefaultThreshold,
 			Usage: `Threshold for rebalance redis cluster.`,
 		},
+		cli.StringFlag{
+			Name:  "plan-out",
+			Value: "",
+			Usage: `Save the plan to a JSON file to be executed later with apply, instead of running it.`,
+		},
 		cli.StringFlag{
 			Name:  "password, a",
 			Value: "",
@@ -117,6 +134,16 @@ func (rt *RedisTrib) RebalanceClusterCmd(context *cli.Context) error {
 	}
 	useEmpty := context.Bool("use-empty-masters")
 
+	// With --auto-weights the cluster is balanced by the number of keys
+	// unless an other kind of load was asked.
+	balanceBy := strings.ToLower(context.String("balance-by"))
+	if context.Bool("auto-weights") && balanceBy == "slots" {
+		balanceBy = "keys"
+	}
+	if balanceBy != "slots" && balanceBy != "keys" && balanceBy != "memory" {
+		logrus.Fatalf("Invalid balance-by %q for rebalance: slots, keys or memory.", balanceBy)
+	}
+
 	// Assign a weight to each node, and compute the total cluster weight.
 	totalWeight := 0
 	nodesInvolved := 0
@@ -142,9 +169,29 @@ func (rt *RedisTrib) RebalanceClusterCmd(context *cli.Context) error {
 		logrus.Fatalf("*** Please fix your cluster problem before rebalancing.")
 	}
 
-	// Calculate the slots balance for each node. It's the number of
-	// slots the node should lose (if positive) or gain (if negative)
-	// in order to be balanced.
+	// The cost of every slot when not balancing by slot counts.
+	var costs map[int]int64
+	if balanceBy != "slots" {
+		var err error
+		if costs, err = rt.SlotCosts(balanceBy, context.Int("samples")); err != nil {
+			return err
+		}
+	}
+
+	// The load of every node, as the number of slots, keys or bytes
+	// it serves.
+	loads := make(map[string]int64)
+	var totalLoad int64
+	for _, node := range rt.Nodes() {
+		if node.HasFlag("master") && node.Weight() != 0 {
+			loads[node.Name()] = SlotsLoad(node.Slots(), costs)
+			totalLoad += loads[node.Name()]
+		}
+	}
+
+	// Calculate the balance for each node. It's the load the node
+	// should lose (if positive) or gain (if negative) in order to be
+	// balanced.
 	threshold := context.Int("threshold")
 	thresholdReached := false
 	for _, node := range rt.Nodes() {
@@ -152,16 +199,17 @@ func (rt *RedisTrib) RebalanceClusterCmd(context *cli.Context) error {
 			if node.Weight() == 0 {
 				continue
 			}
-			expected := int((float64(ClusterHashSlots) / float64(totalWeight)) * float64(node.Weight()))
-			node.SetBalance(len(node.Slots()) - expected)
+			load := loads[node.Name()]
+			expected := int64((float64(totalLoad) / float64(totalWeight)) * float64(node.Weight()))
+			node.SetBalance(load - expected)
 			// Compute the percentage of difference between the
-			// expected number of slots and the real one, to see
-			// if it's over the threshold specified by the user.
+			// expected load and the real one, to see if it's over
+			// the threshold specified by the user.
 			overThreshold := false
 
 			if threshold > 0 {
-				if len(node.Slots()) > 0 {
-					errPerc := math.Abs(float64(100 - (100.0*expected)/len(node.Slots())))
+				if load > 0 {
+					errPerc := math.Abs(100 - (100.0*float64(expected))/float64(load))
 					if int(errPerc) > threshold {
 						overThreshold = true
 					}
@@ -191,94 +239,102 @@ func (rt *RedisTrib) RebalanceClusterCmd(context *cli.Context) error {
 	// Because of rounding, it is possible that the balance of all nodes
 	// summed does not give 0. Make sure that nodes that have to provide
 	// slots are always matched by nodes receiving slots.
-	//total_balance = sn.map{|x| x.info[:balance]}.reduce{|a,b| a+b}
-	totalBalance := 0
+	var totalBalance int64
 	for _, node := range sn {
 		totalBalance += node.Balance()
 	}
 
 	for totalBalance > 0 {
+		receivers := 0
 		for _, node := range sn {
 			if node.Balance() < 0 && totalBalance > 0 {
 				b := node.Balance() - 1
 				node.SetBalance(b)
 				totalBalance -= 1
+				receivers += 1
 			}
 		}
+		if receivers == 0 {
+			break
+		}
 	}
 
-	// TODO:
-	// Sort nodes by their slots balance.
-	sort.Sort(BalanceArray(sn))
-
-	logrus.Printf(">>> Rebalancing across %d nodes. Total weight = %d", nodesInvolved, totalWeight)
+	logrus.Printf(">>> Rebalancing across %d nodes by %s. Total weight = %d", nodesInvolved, balanceBy, totalWeight)
 
 	if context.GlobalBool("verbose") {
 		for _, node := range sn {
-			logrus.Printf("%s balance is %d slots", node.String(), node.Balance())
+			logrus.Printf("%s balance is %d %s", node.String(), node.Balance(), balanceBy)
 		}
 	}
 
-	// Now we have at the start of the 'sn' array nodes that should get
-	// slots, at the end nodes that must give slots.
-	// We take two indexes, one at the start, and one at the end,
-	// incrementing or decrementing the indexes accordingly til we
-	// find nodes that need to get/provide slots.
-	// TODO: check the logic of code
-	dstIdx := 0
-	srcIdx := len(sn) - 1
-
-	for dstIdx < srcIdx {
-		dst := sn[dstIdx]
-		src := sn[srcIdx]
-
-		var numSlots float64
-		if math.Abs(float64(dst.Balance())) < math.Abs(float64(src.Balance())) {
-			numSlots = math.Abs(float64(dst.Balance()))
-		} else {
-			numSlots = math.Abs(float64(src.Balance()))
+	plan := rt.PlanRebalance(sn, costs)
+	logrus.Printf("  Rebalancing plan:")
+	plan.Show()
+	logrus.Printf(">>> Simulated slots distribution:")
+	plan.ShowDistribution(sn, costs, strings.ToUpper(balanceBy))
+
+	if path := context.String("plan-out"); path != "" {
+		return rt.SavePlan(plan, path, "rebalance", addr)
+	}
+
+	if context.Bool("simulate") {
+		return nil
+	}
+
+	opts := &MoveOpts{
+		Quiet:    true,
+		Dots:     false,
+		Update:   true,
+		Pipeline: context.Int("pipeline"),
+	}
+	return rt.ExecutePlan(plan, opts)
+}
+
+// Return the cost of every slot served by the masters: the number of
+// keys it holds, or an estimation of their memory usage based on
+// 'samples' keys for every slot.
+func (rt *RedisTrib) SlotCosts(balanceBy string, samples int) (map[int]int64, error) {
+	costs := make(map[int]int64)
+
+	for _, node := range rt.Nodes() {
+		if !node.HasFlag("master") || len(node.Slots()) == 0 {
+			continue
 		}
 
-		if numSlots > 0 {
-			logrus.Printf("Moving %f slots from %s to %s", numSlots, src.String(), dst.String())
+		logrus.Printf(">>> Sampling %s of %d slots on %s", balanceBy, len(node.Slots()), node.String())
+		counts, err := node.SlotsKeyCount()
+		if err != nil {
+			return nil, fmt.Errorf("count keys in slots of %s failed: %s", node.String(), err.Error())
+		}
 
-			// Actaully move the slots.
-			// TODO: add move slot code.
-			srcs := ClusterArray{*src}
-			reshardTable := rt.ComputeReshardTable(srcs, int(numSlots))
-			if len(reshardTable) != int(numSlots) {
-				logrus.Fatalf("*** Assertio failed: Reshard table != number of slots")
+		for slot, count := range counts {
+			if balanceBy == "keys" || count == 0 {
+				costs[slot] = int64(count)
+				continue
 			}
 
-			if context.Bool("simulate") {
-				logrus.Printf("%s", strings.Repeat("#", len(reshardTable)))
-			} else {
-				opts := &MoveOpts{
-					Quiet:    true,
-					Dots:     false,
-					Update:   true,
-					Pipeline: context.Int("pipeline"),
-				}
-				for _, e := range reshardTable {
-					rt.MoveSlot(e, dst, opts)
-					logrus.Printf("#")
-				}
+			avg, err := node.SampleSlotMemory(slot, samples)
+			if err != nil {
+				return nil, fmt.Errorf("sample memory of slot %d on %s failed: %s", slot, node.String(), err.Error())
 			}
-			logrus.Printf("\n")
+			costs[slot] = avg * int64(count)
 		}
+	}
+	return costs, nil
+}
 
-		// Update nodes balance.
-		dst.SetBalance(dst.Balance() + int(numSlots))
-		src.SetBalance(src.Balance() - int(numSlots))
-		if dst.Balance() == 0 {
-			dstIdx += 1
-		}
-		if src.Balance() == 0 {
-			srcIdx -= 1
-		}
+// Return the load of a set of slots: their number if there is no
+// cost, the sum of their costs otherwise.
+func SlotsLoad(slots map[int]int, costs map[int]int64) int64 {
+	if costs == nil {
+		return int64(len(slots))
 	}
 
-	return nil
+	var load int64
+	for slot := range slots {
+		load += costs[slot]
+	}
+	return load
 }
 
 ///////////////////////////////////////////////////////////
@@ -294,5 +350,8 @@ func (b BalanceArray) Swap(i, j int) {
 }
 
 func (b BalanceArray) Less(i, j int) bool {
-	return b[i].Balance() < b[j].Balance()
+	if b[i].Balance() != b[j].Balance() {
+		return b[i].Balance() < b[j].Balance()
+	}
+	return b[i].Name() < b[j].Name()
 }
@@ -3,15 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
-	"math"
 	"math/rand"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/garyburd/redigo/redis"
 )
 
 var strToLower = strings.ToLower
@@ -21,10 +20,12 @@ const (
 	MigrateDefaultTimeout     = 60000
 	MigrateDefaultPipeline    = 10
 	RebalanceDefaultThreshold = 2
+	RebalanceDefaultSamples   = 10
 )
 
 type RedisTrib struct {
 	nodes       []*ClusterNode
+	failedNodes []*NodeInfo // known by the cluster but not loaded
 	fix         bool
 	errors      []error
 	timeout     int
@@ -50,6 +51,21 @@ func (rt *RedisTrib) Nodes() [](*ClusterNode) {
 
 func (rt *RedisTrib) ResetNodes() {
 	rt.nodes = []*ClusterNode{}
+	rt.failedNodes = []*NodeInfo{}
+}
+
+// Close the connections to all the loaded nodes.
+func (rt *RedisTrib) Close() {
+	for _, node := range rt.Nodes() {
+		node.Close()
+	}
+}
+
+// Return the nodes listed in the cluster configuration that could not
+// be loaded: flagged as failed, without address, still in handshake or
+// simply unreachable.
+func (rt *RedisTrib) FailedNodes() []*NodeInfo {
+	return rt.failedNodes
 }
 
 func (rt *RedisTrib) SetFix(fix bool) {
@@ -148,6 +164,7 @@ func (rt *RedisTrib) CheckCluster(quiet bool) {
 	rt.CheckConfigConsistency()
 	rt.CheckOpenSlots()
 	rt.CheckSlotsCoverage()
+	rt.CheckFailedNodes()
 }
 
 func (rt *RedisTrib) ShowClusterInfo() {
@@ -310,7 +327,7 @@ func (rt *RedisTrib) FixOpenSlot(slot string) {
 		// TODO: add fix open slot code here
 		// Use ADDSLOTS to assign the slot.
 		logrus.Printf("*** Configuring %s as the slot owner", owner.String())
-		owner.ClusterSetSlot(slotnum, "stable")
+		owner.ClusterSetSlot(slotnum, "stable", "")
 		owner.ClusterAddSlots(slotnum)
 		// Make sure this information will propagate. Not strictly needed
 		// since there is no past owner, so all the other nodes will accept
@@ -394,7 +411,7 @@ func (rt *RedisTrib) CheckOpenSlots() {
 
 	for _, node := range rt.Nodes() {
 		if len(node.Migrating()) > 0 {
-			keys := make([]string, len(node.Migrating()))
+			keys := make([]string, 0, len(node.Migrating()))
 			for k, _ := range node.Migrating() {
 				keys = append(keys, strconv.Itoa(k))
 			}
@@ -403,7 +420,7 @@ func (rt *RedisTrib) CheckOpenSlots() {
 			openSlots = append(openSlots, keys...)
 		}
 		if len(node.Importing()) > 0 {
-			keys := make([]string, len(node.Importing()))
+			keys := make([]string, 0, len(node.Importing()))
 			for k, _ := range node.Importing() {
 				keys = append(keys, strconv.Itoa(k))
 			}
@@ -423,6 +440,53 @@ func (rt *RedisTrib) CheckOpenSlots() {
 	}
 }
 
+func (rt *RedisTrib) CheckFailedNodes() {
+	logrus.Printf(">>> Check for failed nodes...")
+	if len(rt.FailedNodes()) == 0 {
+		logrus.Printf("[OK] All known nodes are reachable.")
+		return
+	}
+
+	for _, n := range rt.FailedNodes() {
+		var state string
+		switch {
+		case n.HasFlag("handshake"):
+			state = "in handshake"
+		case n.HasFlag("noaddr"):
+			state = "without address"
+		case n.HasFlag("fail"):
+			state = "failed"
+		default:
+			state = "unreachable"
+		}
+		logrus.Warnf("[WARNING] Node %s (%s) is %s, flags: %s, %d slots.",
+			n.String(), n.Name(), state, n.Flags(), len(n.Slots()))
+	}
+	logrus.Warnf("%d node(s) can not be reached, use forget-failed to remove ghost nodes.",
+		len(rt.FailedNodes()))
+}
+
+// Return the sorted list of slots in migrating or importing state
+// in any of the nodes.
+func (rt *RedisTrib) OpenSlots() []int {
+	open := make(map[int]bool)
+	for _, node := range rt.Nodes() {
+		for slot := range node.Migrating() {
+			open[slot] = true
+		}
+		for slot := range node.Importing() {
+			open[slot] = true
+		}
+	}
+
+	slots := make([]int, 0, len(open))
+	for slot := range open {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
 func (rt *RedisTrib) NodesWithKeysInSlot(slot int) (nodes [](*ClusterNode)) {
 	for _, node := range rt.Nodes() {
 		if node.HasFlag("slave") {
@@ -505,7 +569,7 @@ func (rt *RedisTrib) FixSlotsCoverage() {
 			if target != nil {
 				logrus.Printf(">>> Covering slot %d moving keys to %s", slot, target.String())
 				target.ClusterAddSlots(slot)
-				target.ClusterSetSlot(slot, "stable")
+				target.ClusterSetSlot(slot, "stable", "")
 				nodes := slots[slot]
 				for _, src := range nodes {
 					if src == target {
@@ -516,7 +580,7 @@ func (rt *RedisTrib) FixSlotsCoverage() {
 					// Set the source node in 'importing' state (even if we will
 					// actually migrate keys away) in order to avoid receiving
 					// redirections for MIGRATE.
-					src.ClusterSetSlot(slot, "importing")
+					src.ClusterSetSlot(slot, "importing", target.Name())
 					//move_slot(src,target,slot,:dots=>true,:fix=>true,:cold=>true)
 					src.ClusterAddSlots(slot)
 				}
@@ -548,19 +612,44 @@ func (rt *RedisTrib) LoadClusterInfoFromNode(addr string) error {
 	if !node.AssertCluster() {
 		logrus.Fatalf("Node %s is not configured as a cluster node.", node.String())
 	}
+	return rt.loadClusterInfo(node)
+}
+
+// Same as LoadClusterInfoFromNode, but return an error instead of
+// exiting when the node can't be reached or is not a cluster node.
+func (rt *RedisTrib) TryLoadClusterInfoFromNode(addr string) error {
+	node := NewClusterNode(addr)
+
+	if err := node.Connect(false); err != nil {
+		return err
+	}
+	if !node.AssertCluster() {
+		node.Close()
+		return fmt.Errorf("node %s is not configured as a cluster node", node)
+	}
+	return rt.loadClusterInfo(node)
+}
+
+// Load the cluster info from the view of the connected 'node'.
+func (rt *RedisTrib) loadClusterInfo(node *ClusterNode) error {
 	if err := node.LoadInfo(true); err != nil {
+		node.Close()
 		return fmt.Errorf("load info from node %s failed", node)
 	}
 	rt.AddNode(node)
 
 	for _, n := range node.Friends() {
-		if n.HasFlag("noaddr") || n.HasFlag("disconnected") || n.HasFlag("fail") {
+		// A node in PFAIL state (fail?) may still be reachable, the
+		// connect below tells.
+		if n.HasFlag("noaddr") || n.HasFlag("fail") || n.HasFlag("handshake") {
+			rt.failedNodes = append(rt.failedNodes, n)
 			continue
 		}
 
 		fnode := NewClusterNode(n.String())
 		fnode.Connect(false)
 		if fnode.R() == nil {
+			rt.failedNodes = append(rt.failedNodes, n)
 			continue
 		}
 
@@ -596,43 +685,31 @@ type InterfaceErrorCombo struct {
 	err    error
 }
 
-type EachFunction func(*ClusterNode, interface{}, error, string, []interface{})
-
-func (rt *RedisTrib) EachRunCommand(f EachFunction, cmd string, args ...interface{}) ([]*InterfaceErrorCombo, error) {
-	nodes := rt.Nodes()
-
+// Run the command on the nodes, at most 'workers' at once, and return
+// the replies in the order of the nodes.
+func RunCommandOnNodes(nodes []*ClusterNode, workers int, cmd string, args ...interface{}) []*InterfaceErrorCombo {
+	if workers <= 0 {
+		workers = 1
+	}
 	ies := make([]*InterfaceErrorCombo, len(nodes))
+	sem := make(chan struct{}, workers)
 
+	var wg sync.WaitGroup
 	for i, node := range nodes {
-		val, err := node.Call(cmd, args...)
-		ie := &InterfaceErrorCombo{val, err}
-		ies[i] = ie
-
-		if f != nil {
-			f(node, val, err, cmd, args)
-		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, node *ClusterNode) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			val, err := node.Call(cmd, args...)
+			ies[i] = &InterfaceErrorCombo{val, err}
+		}(i, node)
 	}
+	wg.Wait()
 
-	return ies, nil
-}
-
-func (rt *RedisTrib) EachRunCommandAndPrint(cmd string, args ...interface{}) ([]*InterfaceErrorCombo, error) {
-	return rt.EachRunCommand(
-		func(node *ClusterNode, result interface{}, err error, cmd string, args []interface{}) {
-			val, _ := redis.String(result, err)
-
-			if len(args) > 0 {
-				strArgs := ToStringArray(args)
-				logrus.Printf("%s: %s %s \n%s",
-					node.String(), cmd, strings.Join(strArgs, " "), strings.Trim(val, " \n"))
-			} else {
-				logrus.Printf("%s: %s\n%s", node.String(), cmd, strings.Trim(val, " \n"))
-			}
-
-			if err != nil {
-				logrus.Errorf("%s", err)
-			}
-		}, cmd, args...)
+	return ies
 }
 
 // Option struct for move slot
@@ -655,7 +732,6 @@ type MoveOpts struct {
 //  :update  -- Update nodes.info[:slots] for source/target nodes.
 //  :quiet   -- Don't print info messages.
 func (rt *RedisTrib) MoveSlot(source *MovedNode, target *ClusterNode, o *MoveOpts) {
-	// TODO: add move slot code
 	if o.Pipeline <= 0 {
 		o.Pipeline = MigrateDefaultPipeline
 	}
@@ -665,41 +741,46 @@ func (rt *RedisTrib) MoveSlot(source *MovedNode, target *ClusterNode, o *MoveOpt
 	// the operations is important, as otherwise a client may be redirected
 	// to the target node that does not yet know it is importing this slot.
 	if !o.Quiet {
-		logrus.Printf("Moving slot %d from %s to %s: ", source.Slot, source.Source.InfoString(), target.InfoString())
+		logrus.Printf("Moving slot %d from %s to %s: ", source.Slot, source.Source.String(), target.String())
 	}
 
 	if !o.Cold {
-		target.ClusterSetSlot(source.Slot, "importing")
-		source.Source.ClusterSetSlot(source.Slot, "migrating")
+		if _, err := target.ClusterSetSlot(source.Slot, "importing", source.Source.Name()); err != nil {
+			logrus.Fatalf("[ERR] Set slot %d importing on %s: %s", source.Slot, target.String(), err.Error())
+		}
+		if _, err := source.Source.ClusterSetSlot(source.Slot, "migrating", target.Name()); err != nil {
+			logrus.Fatalf("[ERR] Set slot %d migrating on %s: %s", source.Slot, source.Source.String(), err.Error())
+		}
 	}
 
 	// Migrate all the keys from source to target using the MIGRATE command
 	for {
-		keystr, err := source.Source.ClusterGetKeysInSlot(source.Slot, o.Pipeline)
-		keys := strings.Split(keystr, "\n")
-		if err == nil {
-			if len(keys) == 0 {
-				break
-			}
+		keys, err := source.Source.ClusterGetKeysInSlot(source.Slot, o.Pipeline)
+		if err != nil {
+			logrus.Fatalf("[ERR] Getting keys of slot %d from %s: %s", source.Slot, source.Source.String(), err.Error())
+		}
+		if len(keys) == 0 {
+			break
+		}
 
-			// XXX: migrate parameters check
-			var cmd []interface{}
-			cmd = append(cmd, target.Host(), target.Port(), "", 0, rt.Timeout(), false, true, keys)
-			_, err := source.Source.Call("migrate", cmd...)
-			// _, _ = source.Source.Call("migrate", target.Host(), target.Port(), "", 0, rt.Timeout(), "REPLACE", "KEYS", keys...)
-			if err != nil {
-				errinfo := err.Error()
-				if o.Fix && strings.Contains(errinfo, "BUSYKEY") {
-					logrus.Printf("*** Target key exists. Replacing it for FIX.")
-					// XXX: migrate parameters check
-					cmd = cmd[:0]
-					cmd = append(cmd, target.Host(), target.Port(), "", 0, rt.Timeout(), true, true, keys)
-					_, _ = source.Source.Call("migrate", cmd...)
-					// _, _ = source.Source.Call("migrate", target.Host(), target.Port(), "", 0, rt.Timeout(), keys, keys)
-				} else {
-					logrus.Printf("\n")
-					logrus.Fatalf("[ERR] Calling MIGRATE: %s", errinfo)
+		cmd := []interface{}{target.Host(), target.Port(), "", 0, rt.Timeout()}
+		if o.Fix {
+			cmd = append(cmd, "REPLACE")
+		}
+		cmd = append(cmd, "KEYS")
+		cmd = append(cmd, ToInterfaceArray(keys)...)
+
+		if _, err := source.Source.Call("MIGRATE", cmd...); err != nil {
+			errinfo := err.Error()
+			if o.Fix && strings.Contains(errinfo, "BUSYKEY") {
+				logrus.Printf("*** Target key exists. Replacing it for FIX.")
+				cmd = []interface{}{target.Host(), target.Port(), "", 0, rt.Timeout(), "REPLACE", "KEYS"}
+				cmd = append(cmd, ToInterfaceArray(keys)...)
+				if _, err := source.Source.Call("MIGRATE", cmd...); err != nil {
+					logrus.Fatalf("[ERR] Calling MIGRATE REPLACE: %s", err.Error())
 				}
+			} else {
+				logrus.Fatalf("[ERR] Calling MIGRATE: %s", errinfo)
 			}
 		}
 		if o.Dots {
@@ -707,80 +788,21 @@ func (rt *RedisTrib) MoveSlot(source *MovedNode, target *ClusterNode, o *MoveOpt
 		}
 	}
 
-	//puts if !o[:quiet]
-	if !o.Quiet {
-		logrus.Printf("\n")
-	}
-
 	// Set the new node as the owner of the slot in all the known nodes.
 	if !o.Cold {
 		for _, n := range rt.Nodes() {
 			if n.HasFlag("slave") {
 				continue
 			}
-			//  n.r.cluster("setslot",slot,"node",target.info[:name])
+			if _, err := n.ClusterSetSlot(source.Slot, "node", target.Name()); err != nil {
+				logrus.Errorf("Set slot %d node %s on %s: %s", source.Slot, target.Name(), n.String(), err.Error())
+			}
 		}
 	}
 
 	// Update the node logical config
 	if o.Update {
-		//source.info[:slots].delete(slot)
-		//target.info[:slots][slot] = true
-	}
-}
-
-// Given a list of source nodes return a "resharding plan"
-// with what slots to move in order to move "numslots" slots to another
-// instance.
-func (rt *RedisTrib) ComputeReshardTable(sources ClusterArray, numSlots int) []*MovedNode {
-	// defined in clusternode.go
-	var moved []*MovedNode
-	// Sort from bigger to smaller instance, for two reasons:
-	// 1) If we take less slots than instances it is better to start
-	//    getting from the biggest instances.
-	// 2) We take one slot more from the first instance in the case of not
-	//    perfect divisibility. Like we have 3 nodes and need to get 10
-	//    slots, we take 4 from the first, and 3 from the rest. So the
-	//    biggest is always the first.
-	sort.Sort(ClusterArray(sources))
-
-	sourceTotSlots := 0
-	for _, node := range sources {
-		sourceTotSlots += len(node.Slots())
-	}
-
-	for idx, node := range sources {
-		n := float64(numSlots) / float64(sourceTotSlots*len(node.Slots()))
-
-		if idx == 0 {
-			n = math.Ceil(n)
-		} else {
-			n = math.Floor(n)
-		}
-
-		keys := make([]int, len(node.Slots()))
-		i := 0
-		for k, _ := range node.Slots() {
-			keys[i] = k
-			i++
-		}
-		sort.Ints(keys)
-
-		for i := 0; i < int(n); i++ {
-			if len(moved) < numSlots {
-				mnode := &MovedNode{
-					Source: node,
-					Slot:   keys[i],
-				}
-				moved = append(moved, mnode)
-			}
-		}
-	}
-	return moved
-}
-
-func (rt *RedisTrib) ShowReshardTable(table []*MovedNode) {
-	for _, node := range table {
-		logrus.Printf("    Moving slot %d from %s", node.Slot, node.Source.Name())
+		delete(source.Source.Slots(), source.Slot)
+		target.Slots()[source.Slot] = AssignedHashSlot
 	}
 }
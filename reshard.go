@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -16,7 +17,10 @@ import (
 //                  --from <arg>
 //                  --to <arg>
 //                  --slots <arg>
+//                  --slots-range <arg>
 //                  --yes
+//                  --simulate
+//                  --plan-out <arg>
 //                  --timeout <arg>
 //                  --pipeline <arg>
 var reshardCommand = cli.Command{
@@ -37,10 +41,19 @@ var reshardCommand = cli.Command{
 			Name:  "slots",
 			Usage: `Slots for reshard redis cluster.`,
 		},
+		cli.StringFlag{
+			Name:  "slots-range",
+			Value: "",
+			Usage: `Exact slots to move to --to, like 100-200,5000, taken from their current owners.`,
+		},
 		cli.BoolFlag{
 			Name:  "yes",
 			Usage: `Auto agree the config for reshard.`,
 		},
+		cli.BoolFlag{
+			Name:  "simulate",
+			Usage: `Only show the resharding plan and the resulting slots distribution.`,
+		},
 		cli.IntFlag{
 			Name:  "timeout",
 			Usage: `Timeout for reshard the redis cluster.`,
@@ -50,6 +63,11 @@ var reshardCommand = cli.Command{
 			Value: "",
 			Usage: `Pipeline for reshard redis cluster.`,
 		},
+		cli.StringFlag{
+			Name:  "plan-out",
+			Value: "",
+			Usage: `Save the plan to a JSON file to be executed later with apply, instead of running it.`,
+		},
 		cli.StringFlag{
 			Name:  "password, a",
 			Value: "",
@@ -96,6 +114,10 @@ func (rt *RedisTrib) ReshardClusterCmd(context *cli.Context) error {
 		rt.SetTimeout(context.Int("timeout"))
 	}
 
+	if context.String("slots-range") != "" {
+		return rt.reshardSlotsRange(context, addr)
+	}
+
 	// Get number of slots
 	var numSlots int
 	if context.Int("slots") != 0 {
@@ -146,7 +168,8 @@ func (rt *RedisTrib) ReshardClusterCmd(context *cli.Context) error {
 	}
 
 	// Get the source instances
-	var sources []interface{}
+	var sources []*ClusterNode
+	all := false
 	from := strings.TrimSpace(context.String("from"))
 	if from != "" {
 		srcArray := strings.Split(from, ",")
@@ -154,8 +177,7 @@ func (rt *RedisTrib) ReshardClusterCmd(context *cli.Context) error {
 		for _, nodeID := range srcArray {
 			nodeID = strings.TrimSpace(nodeID)
 			if nodeID == "all" {
-				sources = sources[:0]
-				sources = append(sources, "all")
+				all = true
 				break
 			} else {
 				node := rt.GetNodeByName(nodeID)
@@ -180,8 +202,7 @@ func (rt *RedisTrib) ReshardClusterCmd(context *cli.Context) error {
 			if text == "done" {
 				break
 			} else if text == "all" {
-				sources = sources[:0]
-				sources = append(sources, "all")
+				all = true
 				break
 			} else if src == nil || src.HasFlag("slave") {
 				logrus.Warningf("*** The specified node is not known or not a master, please retry.")
@@ -193,53 +214,116 @@ func (rt *RedisTrib) ReshardClusterCmd(context *cli.Context) error {
 		}
 	}
 
-	if len(sources) <= 0 {
-		logrus.Fatalf("*** No source nodes given, operation aborted")
-	}
-
-	if len(sources) == 1 {
-		first := sources[0]
-
-		// Handle soures == all.
-		str, found := first.(string)
-		if found && str == "all" {
-			sources = sources[:0]
-
-			for _, node := range rt.Nodes() {
-				if node.Name() == target.Name() || node.HasFlag("slave") {
-					continue
-				}
-				sources = append(sources, node)
+	// Handle soures == all.
+	if all {
+		sources = sources[:0]
+		for _, node := range rt.Nodes() {
+			if node.Name() == target.Name() || node.HasFlag("slave") {
+				continue
 			}
+			sources = append(sources, node)
 		}
 	}
 
+	if len(sources) <= 0 {
+		logrus.Fatalf("*** No source nodes given, operation aborted")
+	}
+
 	// Check if the destination node is the same of any source nodes.
 	for _, node := range sources {
-		if node != nil {
-			if cnode, ok := node.(ClusterNode); ok {
-				if cnode.Name() == target.Name() {
-					logrus.Fatalf("*** Target node is also listed among the source nodes!")
-				}
-			}
+		if node.Name() == target.Name() {
+			logrus.Fatalf("*** Target node is also listed among the source nodes!")
 		}
 	}
 
 	logrus.Printf("Ready to move %d slots.", numSlots)
 	logrus.Printf("  Source nodes:")
-	var srcs ClusterArray
 	for _, node := range sources {
-		if cnode, ok := node.(ClusterNode); ok {
-			fmt.Printf("\t%s", cnode.InfoString())
-			srcs = append(srcs, cnode)
-		}
+		logrus.Printf("\t%s", node.InfoString())
 	}
 	logrus.Printf("  Destination node: %s", target.InfoString())
 
-	// TODO: ComputeReshardTable
-	reshardTable := rt.ComputeReshardTable(srcs, numSlots)
+	plan := rt.PlanReshard(sources, target, numSlots)
+	logrus.Printf("  Resharding plan:")
+	plan.Show()
+	if plan.NumSlots() != numSlots {
+		logrus.Warnf("*** Only %d slots can be moved from the source nodes.", plan.NumSlots())
+	}
+	logrus.Printf(">>> Resulting slots distribution:")
+	plan.ShowDistribution(append(append([]*ClusterNode{}, sources...), target), nil, "")
+
+	return rt.runReshardPlan(context, addr, plan)
+}
+
+// Reshard exactly the slots given with --slots-range to the --to node,
+// from whatever master currently owns each of them.
+func (rt *RedisTrib) reshardSlotsRange(context *cli.Context, addr string) error {
+	slots, err := ParseNumRange(context.String("slots-range"), ClusterHashSlots)
+	if err != nil || len(slots) == 0 {
+		return fmt.Errorf("invalid slots range %q, slots must be between 0 and %d",
+			context.String("slots-range"), ClusterHashSlots-1)
+	}
+
+	target := rt.GetNodeByName(context.String("to"))
+	if target == nil || target.HasFlag("slave") {
+		logrus.Fatalf("*** The node given with --to is not known or not a master.")
+	}
+
+	// Validate the ownership of every slot before moving anything.
+	open := make(map[int]bool)
+	for _, slot := range rt.OpenSlots() {
+		open[slot] = true
+	}
+	bySource := make(map[*ClusterNode][]int)
+	var sources []*ClusterNode
+	for _, slot := range slots {
+		owners := rt.GetSlotOwners(slot)
+		if len(owners) != 1 {
+			return fmt.Errorf("slot %d is served by %d masters", slot, len(owners))
+		}
+		if open[slot] {
+			return fmt.Errorf("slot %d is in migrating or importing state", slot)
+		}
+
+		owner := owners[0]
+		if owner == target {
+			logrus.Warnf("*** Slot %d is already served by %s, skipping.", slot, target.String())
+			continue
+		}
+		if _, ok := bySource[owner]; !ok {
+			sources = append(sources, owner)
+		}
+		bySource[owner] = append(bySource[owner], slot)
+	}
+
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name() < sources[j].Name() })
+	plan := &ReshardPlan{}
+	for _, source := range sources {
+		plan.Add(source, target, bySource[source])
+	}
+	if plan.NumSlots() == 0 {
+		logrus.Printf("[OK] Nothing to move.")
+		return nil
+	}
+
+	logrus.Printf("Ready to move %d slots to %s.", plan.NumSlots(), target.InfoString())
 	logrus.Printf("  Resharding plan:")
-	rt.ShowReshardTable(reshardTable)
+	plan.Show()
+	logrus.Printf(">>> Resulting slots distribution:")
+	plan.ShowDistribution(append(sources, target), nil, "")
+
+	return rt.runReshardPlan(context, addr, plan)
+}
+
+// Save, or after confirmation execute, a resharding plan.
+func (rt *RedisTrib) runReshardPlan(context *cli.Context, addr string, plan *ReshardPlan) error {
+	if path := context.String("plan-out"); path != "" {
+		return rt.SavePlan(plan, path, "reshard", addr)
+	}
+
+	if context.Bool("simulate") {
+		return nil
+	}
 
 	if !context.Bool("yes") {
 		fmt.Printf("Do you want to proceed with the proposed reshard plan (yes/no)? ")
@@ -260,13 +344,8 @@ func (rt *RedisTrib) ReshardClusterCmd(context *cli.Context) error {
 	}
 	opts := &MoveOpts{
 		Dots:     true,
+		Update:   true,
 		Pipeline: pipeline,
 	}
-	// TODO: Move slots
-	for _, e := range reshardTable {
-		// move slot
-		rt.MoveSlot(e, target, opts)
-	}
-
-	return nil
+	return rt.ExecutePlan(plan, opts)
 }
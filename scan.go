@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/garyburd/redigo/redis"
+)
+
+// scan            host:port
+//                  --match <arg>
+//                  --type <arg>
+//                  --min-ttl <arg>
+//                  --no-ttl
+//                  --count <arg>
+//                  --long
+//                  --prefixes
+//                  --delimiter <arg>
+//                  --depth <arg>
+var scanCommand = cli.Command{
+	Name:        "scan",
+	Usage:       "scan the keys of redis cluster.",
+	ArgsUsage:   `host:port`,
+	Description: `The scan command runs SCAN on every master in parallel and prints the matching keys with their slot and node.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "match",
+			Value: "",
+			Usage: `Only keys matching the glob-style pattern, like 'user:*'.`,
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Value: "",
+			Usage: `Only keys of this type: string, list, set, zset, hash or stream.`,
+		},
+		cli.Int64Flag{
+			Name:  "min-ttl",
+			Usage: `Only keys expiring in at least this many seconds, or never expiring.`,
+		},
+		cli.BoolFlag{
+			Name:  "no-ttl",
+			Usage: `Only keys without an expire.`,
+		},
+		cli.IntFlag{
+			Name:  "count",
+			Value: ScanDefaultCount,
+			Usage: `COUNT hint given to every SCAN call.`,
+		},
+		cli.BoolFlag{
+			Name:  "long",
+			Usage: `Also print the type and the TTL in milliseconds of every key.`,
+		},
+		cli.BoolFlag{
+			Name:  "prefixes",
+			Usage: `Only print the number of matching keys for every key prefix.`,
+		},
+		cli.StringFlag{
+			Name:  "delimiter",
+			Value: ":",
+			Usage: `Delimiter between the parts of the key names for --prefixes.`,
+		},
+		cli.IntFlag{
+			Name:  "depth",
+			Value: 1,
+			Usage: `Number of key parts making a prefix for --prefixes.`,
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is ""`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "scan")
+			logrus.Fatalf("Must provide \"host:port\" for scan command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		rt := NewRedisTrib()
+		if err := rt.ScanClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+const (
+	ScanDefaultCount = 1000
+)
+
+var ScanKeyTypes = []string{"string", "list", "set", "zset", "hash", "stream"}
+
+// Options of a cluster-wide SCAN. The type and TTL of the keys are only
+// fetched when they are needed to filter the keys, or asked with Details.
+type ScanOpts struct {
+	Match   string
+	Type    string
+	MinTTL  int64 // seconds
+	NoTTL   bool
+	Count   int
+	Limit   int // maximum keys per node, 0 for all
+	Details bool
+}
+
+func (o *ScanOpts) needDetails() bool {
+	return o.Details || o.Type != "" || o.MinTTL > 0 || o.NoTTL
+}
+
+// Build the scan options from the common --match, --type, --min-ttl,
+// --no-ttl and --count flags.
+func ScanOptsFromContext(context *cli.Context) (*ScanOpts, error) {
+	opts := &ScanOpts{
+		Match:  context.String("match"),
+		Type:   strings.ToLower(context.String("type")),
+		MinTTL: context.Int64("min-ttl"),
+		NoTTL:  context.Bool("no-ttl"),
+		Count:  context.Int("count"),
+	}
+
+	if opts.Type != "" {
+		valid := false
+		for _, t := range ScanKeyTypes {
+			if t == opts.Type {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid type %q, must be one of %s", opts.Type, strings.Join(ScanKeyTypes, ", "))
+		}
+	}
+	if opts.NoTTL && opts.MinTTL > 0 {
+		return nil, errors.New("--no-ttl and --min-ttl can't be used together")
+	}
+	return opts, nil
+}
+
+// A key found by a cluster-wide SCAN. Type and TTL (in milliseconds,
+// -1 without expire) are only set when the scan fetched them.
+type ScannedKey struct {
+	Key  string
+	Slot int
+	Node *ClusterNode
+	Type string
+	TTL  int64
+}
+
+// Called for every key found, never concurrently. Returning an error
+// stops the scan.
+type ScanFunction func(key *ScannedKey) error
+
+// Run SCAN on every master in parallel, replicas are skipped.
+func (rt *RedisTrib) ScanMasters(opts *ScanOpts, f ScanFunction) error {
+	masters := rt.masters()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var stop error
+	errs := make([]error, len(masters))
+
+	for i, node := range masters {
+		wg.Add(1)
+		go func(i int, node *ClusterNode) {
+			defer wg.Done()
+			errs[i] = node.ScanKeys(opts, func(keys []*ScannedKey) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				for _, key := range keys {
+					if stop != nil {
+						return stop
+					}
+					stop = f(key)
+				}
+				return stop
+			})
+		}(i, node)
+	}
+	wg.Wait()
+
+	if stop != nil {
+		return stop
+	}
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("scan of %s failed: %s", masters[i].String(), err.Error())
+		}
+	}
+	return nil
+}
+
+// Iterate over the keys of the node with SCAN, calling f with every
+// batch of keys passing the filters.
+func (cn *ClusterNode) ScanKeys(opts *ScanOpts, f func(keys []*ScannedKey) error) error {
+	if err := cn.Connect(false); err != nil {
+		return err
+	}
+
+	// The cursor is an unsigned 64 bit number, keep it as a string.
+	cursor := "0"
+	found := 0
+	for {
+		args := []interface{}{cursor}
+		if opts.Match != "" {
+			args = append(args, "MATCH", opts.Match)
+		}
+		if opts.Count > 0 {
+			args = append(args, "COUNT", opts.Count)
+		}
+
+		arr, err := redis.Values(cn.r.Do("SCAN", args...))
+		if err != nil {
+			return err
+		}
+		if cursor, err = redis.String(arr[0], nil); err != nil {
+			return err
+		}
+		names, err := redis.Strings(arr[1], nil)
+		if err != nil {
+			return err
+		}
+
+		keys, err := cn.filterScannedKeys(names, opts)
+		if err != nil {
+			return err
+		}
+		if opts.Limit > 0 && found+len(keys) > opts.Limit {
+			keys = keys[:opts.Limit-found]
+		}
+		found += len(keys)
+		if len(keys) > 0 {
+			if err := f(keys); err != nil {
+				return err
+			}
+		}
+
+		if cursor == "0" || (opts.Limit > 0 && found >= opts.Limit) {
+			return nil
+		}
+	}
+}
+
+func (cn *ClusterNode) filterScannedKeys(names []string, opts *ScanOpts) ([]*ScannedKey, error) {
+	keys := make([]*ScannedKey, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, &ScannedKey{Key: name, Slot: int(Key2Slot(name)), Node: cn, TTL: -1})
+	}
+	if !opts.needDetails() || len(keys) == 0 {
+		return keys, nil
+	}
+
+	for _, key := range keys {
+		cn.r.Send("TYPE", key.Key)
+		cn.r.Send("PTTL", key.Key)
+	}
+	if err := cn.r.Flush(); err != nil {
+		return nil, err
+	}
+
+	filtered := keys[:0]
+	for _, key := range keys {
+		t, err := redis.String(cn.r.Receive())
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := redis.Int64(cn.r.Receive())
+		if err != nil {
+			return nil, err
+		}
+		key.Type, key.TTL = t, ttl
+
+		// Deleted or expired since the SCAN.
+		if t == "none" || ttl == -2 {
+			continue
+		}
+		if opts.Type != "" && t != opts.Type {
+			continue
+		}
+		if opts.NoTTL && ttl != -1 {
+			continue
+		}
+		if opts.MinTTL > 0 && ttl >= 0 && ttl < opts.MinTTL*1000 {
+			continue
+		}
+		filtered = append(filtered, key)
+	}
+	return filtered, nil
+}
+
+func (rt *RedisTrib) ScanClusterCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for scan command")
+	}
+
+	opts, err := ScanOptsFromContext(context)
+	if err != nil {
+		return err
+	}
+	opts.Details = context.Bool("long")
+
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return err
+	}
+
+	// Keys go to stdout, so that the output can be piped while the
+	// progress messages go to the log.
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	prefixes := make(map[string]int)
+	delimiter := context.String("delimiter")
+	depth := context.Int("depth")
+	total := 0
+
+	logrus.Printf(">>> Scanning keys of %d masters", len(rt.masters()))
+	err = rt.ScanMasters(opts, func(key *ScannedKey) error {
+		total++
+		if context.Bool("prefixes") {
+			prefixes[KeyPrefix(key.Key, delimiter, depth)]++
+			return nil
+		}
+
+		if opts.Details {
+			fmt.Fprintf(out, "%s\t%d\t%s\t%s\t%d\n", key.Key, key.Slot, key.Node.String(), key.Type, key.TTL)
+		} else {
+			fmt.Fprintf(out, "%s\t%d\t%s\n", key.Key, key.Slot, key.Node.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if context.Bool("prefixes") {
+		ShowPrefixCounts(out, prefixes)
+	}
+	out.Flush()
+
+	logrus.Printf("[OK] %d keys found.", total)
+	return nil
+}
+
+// Print the number of keys of every prefix, biggest first.
+func ShowPrefixCounts(out *bufio.Writer, prefixes map[string]int) {
+	names := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		names = append(names, prefix)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if prefixes[names[i]] != prefixes[names[j]] {
+			return prefixes[names[i]] > prefixes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	for _, prefix := range names {
+		name := prefix
+		if name == "" {
+			name = "(no prefix)"
+		}
+		fmt.Fprintf(out, "%d\t%s\n", prefixes[prefix], name)
+	}
+}
+
+func (rt *RedisTrib) masters() []*ClusterNode {
+	var masters []*ClusterNode
+	for _, node := range rt.Nodes() {
+		if node.HasFlag("master") {
+			masters = append(masters, node)
+		}
+	}
+	return masters
+}
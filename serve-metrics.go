@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+const (
+	MetricsDefaultInterval = 15
+	MetricsDefaultTimeout  = 5 * time.Second
+)
+
+// serve-metrics   host:port
+//                  --listen <arg>
+//                  --interval <arg>
+//                  --zone <arg>
+var serveMetricsCommand = cli.Command{
+	Name:        "serve-metrics",
+	Usage:       "export redis cluster metrics for prometheus.",
+	ArgsUsage:   `host:port`,
+	Description: `The serve-metrics command periodically reloads the cluster topology and exposes it on /metrics.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "listen",
+			Value: ":9121",
+			Usage: `Address to listen on for the metrics endpoint.`,
+		},
+		cli.IntFlag{
+			Name:  "interval",
+			Value: MetricsDefaultInterval,
+			Usage: `Seconds between two reloads of the cluster topology.`,
+		},
+		cli.StringSliceFlag{
+			Name:  "zone",
+			Value: &cli.StringSlice{},
+			Usage: "Zone label of a node as host:port=zone or node_id=zone, muti times allowed.",
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is ""`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "serve-metrics")
+			logrus.Fatalf("Must provide host:port for serve-metrics command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		if err := ServeMetricsCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+func ServeMetricsCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for serve-metrics command")
+	}
+
+	interval := context.Int("interval")
+	if interval <= 0 {
+		interval = MetricsDefaultInterval
+	}
+
+	// A hung node must not stall the scrapes forever.
+	RedisConnectTimeout = MetricsDefaultTimeout
+	RedisTimeout = MetricsDefaultTimeout
+
+	zones := make(map[string]string)
+	for _, e := range context.StringSlice("zone") {
+		if s := strings.SplitN(e, "=", 2); len(s) == 2 {
+			zones[strings.ToLower(s[0])] = s[1]
+		}
+	}
+
+	var mu sync.RWMutex
+	var metrics []byte
+	refresh := func() {
+		m := CollectClusterMetrics(addr, zones)
+		mu.Lock()
+		metrics = m
+		mu.Unlock()
+	}
+	refresh()
+
+	go func() {
+		for range time.Tick(time.Duration(interval) * time.Second) {
+			refresh()
+		}
+	}()
+
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		mu.RLock()
+		defer mu.RUnlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(metrics)
+	})
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<html><body><h1>%s</h1><a href=\"/metrics\">Metrics</a></body></html>", name)
+	})
+
+	logrus.Printf(">>> Serving metrics of cluster %s on %s/metrics", addr, context.String("listen"))
+	return http.ListenAndServe(context.String("listen"), nil)
+}
+
+// Load the cluster topology from addr and return its metrics in the
+// prometheus text format.
+func CollectClusterMetrics(addr string, zones map[string]string) []byte {
+	start := time.Now()
+	m := newMetricsSet()
+
+	rt := NewRedisTrib()
+	defer rt.Close()
+
+	// The exporter must keep running and report the cluster as down
+	// when a node can't be loaded.
+	if err := rt.TryLoadClusterInfoFromNode(addr); err != nil {
+		logrus.Errorf("Load cluster info from %s failed: %s", addr, err.Error())
+		m.add("redis_cluster_up", "Whether the cluster topology could be loaded.", nil, 0)
+		return m.Bytes()
+	}
+	m.add("redis_cluster_up", "Whether the cluster topology could be loaded.", nil, 1)
+
+	labels := func(node *ClusterNode) []string {
+		role := "master"
+		if node.Replicate() != "" {
+			role = "replica"
+		}
+		zone := zones[strings.ToLower(node.String())]
+		if zone == "" {
+			zone = zones[strings.ToLower(node.Name())]
+		}
+		return []string{"id", node.Name(), "addr", node.String(), "role", role, "zone", zone}
+	}
+
+	for _, node := range rt.Nodes() {
+		if !node.HasFlag("master") {
+			continue
+		}
+		m.add("redis_cluster_master_slots", "Number of slots served by the master.",
+			labels(node), float64(len(node.Slots())))
+		if dbsize, err := node.Dbsize(); err == nil {
+			m.add("redis_cluster_master_keys", "Number of keys stored in the master.",
+				labels(node), float64(dbsize))
+		}
+		m.add("redis_cluster_master_replicas", "Number of replicas of the master.",
+			labels(node), float64(len(node.ReplicasNodes())))
+	}
+
+	for _, nh := range rt.CollectNodesHealth(0, 0) {
+		if nh.State == "" {
+			continue
+		}
+		stateOK := 0.0
+		if nh.State == "ok" {
+			stateOK = 1
+		}
+		m.add("redis_cluster_node_state_ok", "Whether cluster_state is ok in the view of the node.",
+			labels(nh.Node), stateOK)
+		if nh.Node.Replicate() != "" && nh.LinkStatus == "up" {
+			m.add("redis_cluster_node_replication_lag_bytes", "Replication offset difference between the master and the replica.",
+				labels(nh.Node), float64(nh.Lag))
+		}
+	}
+
+	consistent := 0.0
+	if rt.isConfigConsistent() {
+		consistent = 1
+	}
+	m.add("redis_cluster_config_consistent", "Whether all the nodes agree about the slots configuration.", nil, consistent)
+	m.add("redis_cluster_open_slots", "Number of slots in migrating or importing state.", nil, float64(len(rt.OpenSlots())))
+	m.add("redis_cluster_uncovered_slots", "Number of slots not served by any master.", nil,
+		float64(ClusterHashSlots-len(rt.CoveredSlots())))
+	m.add("redis_cluster_nodes", "Number of reachable nodes.", nil, float64(len(rt.Nodes())))
+	m.add("redis_cluster_failed_nodes", "Number of failed or unreachable nodes.", nil, float64(len(rt.FailedNodes())))
+	m.add("redis_cluster_scrape_duration_seconds", "Time spent to collect the cluster metrics.", nil,
+		time.Since(start).Seconds())
+
+	return m.Bytes()
+}
+
+// A set of gauges rendered in the prometheus text format, samples of
+// the same metric are grouped under a single HELP/TYPE header.
+type metricsSet struct {
+	names   []string
+	help    map[string]string
+	samples map[string][]string
+}
+
+func newMetricsSet() *metricsSet {
+	return &metricsSet{
+		help:    make(map[string]string),
+		samples: make(map[string][]string),
+	}
+}
+
+// Add a sample, labels are given as name, value pairs.
+func (m *metricsSet) add(name, help string, labels []string, value float64) {
+	if _, ok := m.help[name]; !ok {
+		m.names = append(m.names, name)
+		m.help[name] = help
+	}
+
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], v))
+	}
+
+	sample := name
+	if len(pairs) > 0 {
+		sample += "{" + strings.Join(pairs, ",") + "}"
+	}
+	m.samples[name] = append(m.samples[name], fmt.Sprintf("%s %g", sample, value))
+}
+
+func (m *metricsSet) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, name := range m.names {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, m.help[name])
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+		samples := m.samples[name]
+		sort.Strings(samples)
+		for _, s := range samples {
+			fmt.Fprintln(&buf, s)
+		}
+	}
+	return buf.Bytes()
+}
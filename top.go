@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+const (
+	TopDefaultInterval = 2
+	TopMaxEvents       = 10
+)
+
+// top             host:port
+//                  --interval <arg>
+//                  --count <arg>
+var topCommand = cli.Command{
+	Name:        "top",
+	Usage:       "display a live view of redis cluster.",
+	ArgsUsage:   `host:port`,
+	Description: `The top command refreshes per node statistics and highlights failovers and slot ownership changes.`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "interval, n",
+			Value: TopDefaultInterval,
+			Usage: `Seconds between two refreshes.`,
+		},
+		cli.IntFlag{
+			Name:  "count, c",
+			Value: 0,
+			Usage: `Number of refreshes before exiting, 0 means forever.`,
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is ""`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "top")
+			logrus.Fatalf("Must provide host:port for top command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		if err := TopClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+// Statistics of a node at one refresh.
+type topNodeStats struct {
+	name    string
+	addr    string
+	role    string
+	master  string
+	ops     string
+	memory  string
+	keys    int
+	slots   int
+	clients string
+	lag     int64
+	changed bool
+}
+
+func TopClusterCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for top command")
+	}
+
+	interval := context.Int("interval")
+	if interval <= 0 {
+		interval = TopDefaultInterval
+	}
+
+	// The dashboard owns the terminal, logs would only garble it.
+	if context.GlobalString("log") == "" {
+		logrus.SetLevel(logrus.FatalLevel)
+	}
+
+	var events []string
+	previous := make(map[string]*topNodeStats)
+	for i := 0; context.Int("count") == 0 || i < context.Int("count"); i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+
+		stats, err := collectTopStats(addr)
+		now := time.Now().Format("15:04:05")
+		if err != nil {
+			events = append(events, fmt.Sprintf("%s %s", now, err.Error()))
+		}
+
+		for _, s := range stats {
+			old, ok := previous[s.name]
+			if !ok {
+				continue
+			}
+			if old.role != s.role {
+				s.changed = true
+				events = append(events, fmt.Sprintf("%s failover: %s (%s) %s -> %s",
+					now, s.addr, s.name[0:8], old.role, s.role))
+			}
+			if old.slots != s.slots {
+				s.changed = true
+				events = append(events, fmt.Sprintf("%s slots: %s (%s) %d -> %d (%+d)",
+					now, s.addr, s.name[0:8], old.slots, s.slots, s.slots-old.slots))
+			}
+		}
+		if len(stats) > 0 {
+			previous = make(map[string]*topNodeStats)
+			for _, s := range stats {
+				previous[s.name] = s
+			}
+		}
+		if len(events) > TopMaxEvents {
+			events = events[len(events)-TopMaxEvents:]
+		}
+
+		renderTop(addr, stats, events)
+	}
+	return nil
+}
+
+// Load the cluster from addr and read the statistics of every node.
+func collectTopStats(addr string) ([]*topNodeStats, error) {
+	rt := NewRedisTrib()
+	defer rt.Close()
+
+	seed := NewClusterNode(addr)
+	err := seed.Connect(false)
+	seed.Close()
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to %s: %s", addr, err.Error())
+	}
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return nil, err
+	}
+
+	var stats []*topNodeStats
+	offsets := make(map[string]int64)
+	replicas := make(map[*topNodeStats]int64)
+	for _, node := range rt.Nodes() {
+		s := &topNodeStats{
+			name:   node.Name(),
+			addr:   node.String(),
+			role:   "master",
+			master: node.Replicate(),
+			slots:  len(node.Slots()),
+		}
+		if node.Replicate() != "" {
+			s.role = "replica"
+		}
+
+		info, err := node.InfoSection("all")
+		if err != nil {
+			continue
+		}
+		s.ops = info["instantaneous_ops_per_sec"]
+		s.memory = info["used_memory_human"]
+		s.clients = info["connected_clients"]
+		s.keys, _ = node.Dbsize()
+
+		if s.role == "master" {
+			offsets[s.name], _ = strconv.ParseInt(info["master_repl_offset"], 10, 64)
+		} else {
+			replicas[s], _ = strconv.ParseInt(info["slave_repl_offset"], 10, 64)
+		}
+		stats = append(stats, s)
+	}
+
+	for s, offset := range replicas {
+		if master, ok := offsets[s.master]; ok && master > offset {
+			s.lag = master - offset
+		}
+	}
+
+	// Show every master followed by its replicas.
+	sort.Slice(stats, func(i, j int) bool {
+		ki, kj := stats[i].name, stats[j].name
+		if stats[i].role != "master" {
+			ki = stats[i].master
+		}
+		if stats[j].role != "master" {
+			kj = stats[j].master
+		}
+		if ki != kj {
+			return ki < kj
+		}
+		if stats[i].role != stats[j].role {
+			return stats[i].role == "master"
+		}
+		return stats[i].addr < stats[j].addr
+	})
+	return stats, nil
+}
+
+func renderTop(addr string, stats []*topNodeStats, events []string) {
+	var table bytes.Buffer
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tID\tROLE\tOPS/SEC\tMEMORY\tKEYS\tSLOTS\tCLIENTS\tLAG")
+	for _, s := range stats {
+		lag := "-"
+		if s.role != "master" {
+			lag = strconv.FormatInt(s.lag, 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			s.addr, s.name[0:8], s.role, s.ops, s.memory, s.keys, s.slots, s.clients, lag)
+	}
+	w.Flush()
+
+	var out bytes.Buffer
+	// Clear the screen and move the cursor to the top left corner.
+	out.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&out, "%s top - %s - %s\n\n", name, addr, time.Now().Format("2006-01-02 15:04:05"))
+	for i, line := range strings.Split(strings.TrimRight(table.String(), "\n"), "\n") {
+		if i > 0 && stats[i-1].changed {
+			line = "\033[1;33m" + line + "\033[0m"
+		}
+		out.WriteString(line + "\n")
+	}
+
+	if len(events) > 0 {
+		out.WriteString("\nEvents:\n")
+		for _, e := range events {
+			out.WriteString("  " + e + "\n")
+		}
+	}
+	os.Stdout.Write(out.Bytes())
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -58,6 +60,48 @@ func MergeNumArray2NumRange(array []int) string {
 	return result
 }
 
+// Parse a list of numbers and ranges like "1-3,5" back into the
+// sorted array [1 2 3 5], the opposite of MergeNumArray2NumRange. The
+// numbers must be between 0 and max-1.
+func ParseNumRange(str string, max int) ([]int, error) {
+	uniq := make(map[int]bool)
+	for _, part := range strings.Split(str, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in %q", bounds[0], str)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+				return nil, fmt.Errorf("invalid number %q in %q", bounds[1], str)
+			}
+		}
+		if last < first {
+			return nil, fmt.Errorf("invalid range %q in %q", part, str)
+		}
+		if first < 0 || last >= max {
+			return nil, fmt.Errorf("range %q in %q is out of 0-%d", part, str, max-1)
+		}
+
+		for i := first; i <= last; i++ {
+			uniq[i] = true
+		}
+	}
+
+	result := make([]int, 0, len(uniq))
+	for i := range uniq {
+		result = append(result, i)
+	}
+	sort.Ints(result)
+	return result, nil
+}
+
 func ToInterfaceArray(in []string) []interface{} {
 	result := make([]interface{}, len(in))
 
@@ -185,21 +229,132 @@ const (
 	DEFAULT_SLOT_NUM = 16384
 )
 
-// Turn a key name into the corrisponding Redis Cluster slot.
-func Key2Slot(key string) uint16 {
-	// Only hash what is inside {...} if there is such a pattern in the key.
-	// Note that the specification requires the content that is between
-	// the first { and the first } after the first {. If we found {} without
-	// nothing in the middle, the whole key is hashed as usually.
-	hashKey := key
-
+// Return the part of the key hashed to compute its slot. Only hash
+// what is inside {...} if there is such a pattern in the key. Note that
+// the specification requires the content that is between the first {
+// and the first } after the first {. If we found {} without nothing in
+// the middle, the whole key is hashed as usually.
+func HashTag(key string) string {
 	start := strings.Index(key, HASHTAG_START)
 	if start >= 0 {
-		end := strings.LastIndex(key, HASHTAG_END)
-		if end >= 0 && start < end {
-			hashKey = key[start:end]
+		end := strings.Index(key[start+1:], HASHTAG_END)
+		if end > 0 {
+			return key[start+1 : start+1+end]
 		}
 	}
+	return key
+}
+
+// Turn a key name into the corrisponding Redis Cluster slot.
+func Key2Slot(key string) uint16 {
+	return crc16(HashTag(key)) % DEFAULT_SLOT_NUM
+}
 
-	return crc16(hashKey) % DEFAULT_SLOT_NUM
+// Return the first 'depth' parts of a key separated by 'delimiter',
+// delimiter included, like "user:" for "user:1000:name". Keys with no
+// such prefix return "".
+func KeyPrefix(key string, delimiter string, depth int) string {
+	if delimiter == "" || depth <= 0 {
+		return ""
+	}
+	parts := strings.SplitN(key, delimiter, depth+1)
+	if len(parts) <= depth {
+		return ""
+	}
+	return strings.Join(parts[:depth], delimiter) + delimiter
+}
+
+// Format a number of bytes the way INFO does for used_memory_human.
+func HumanBytes(n int64) string {
+	switch {
+	case n >= 1<<40:
+		return fmt.Sprintf("%.2fT", float64(n)/(1<<40))
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// Glob-style matching of a key the way SCAN MATCH and KEYS do it,
+// supporting *, ?, [abc], [^a-z] and \ to escape.
+func StringMatch(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if StringMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						match = true
+					}
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if s[0] >= start && s[0] <= end {
+						match = true
+					}
+					pattern = pattern[2:]
+				} else if pattern[0] == s[0] {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s = s[1:]
+			// Unterminated [ ends the pattern.
+			if len(pattern) == 0 {
+				return len(s) == 0
+			}
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
 }
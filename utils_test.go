@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestHashTag(t *testing.T) {
+	cases := []struct {
+		key  string
+		want string
+	}{
+		{"foo", "foo"},
+		{"{user1000}.following", "user1000"},
+		{"{user1000}.followers", "user1000"},
+		{"foo{}{bar}", "foo{}{bar}"},
+		{"{}", "{}"},
+		{"{}{a}", "{}{a}"},
+		{"{a", "{a"},
+		{"a}", "a}"},
+		{"a{b}{c}", "b"},
+		{"foo{{bar}}zap", "{bar"},
+		{"foo{bar}{zap}", "bar"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if got := HashTag(c.key); got != c.want {
+			t.Errorf("HashTag(%q) = %q, want %q", c.key, got, c.want)
+		}
+	}
+}
+
+func TestKey2Slot(t *testing.T) {
+	cases := []struct {
+		key  string
+		slot uint16
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"somekey", 11058},
+		{"123456789", 0x31c3 % ClusterHashSlots},
+		{"{foo}.bar", 12182},
+		{"x{foo}y{bar}", 12182},
+		{"{}foo", crc16("{}foo") % ClusterHashSlots},
+	}
+	for _, c := range cases {
+		if got := Key2Slot(c.key); got != c.slot {
+			t.Errorf("Key2Slot(%q) = %d, want %d", c.key, got, c.slot)
+		}
+	}
+
+	if Key2Slot("{user1000}.following") != Key2Slot("{user1000}.followers") {
+		t.Errorf("keys with the same hash tag are in different slots")
+	}
+}
+
+func TestCrc16(t *testing.T) {
+	// The check value of CRC16-XMODEM.
+	if got := crc16("123456789"); got != 0x31c3 {
+		t.Errorf("crc16(\"123456789\") = %#x, want 0x31c3", got)
+	}
+}
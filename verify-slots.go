@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+// verify-slots    host:port
+//                  --slots-range <arg>
+//                  --retries <arg>
+var verifySlotsCommand = cli.Command{
+	Name:        "verify-slots",
+	Usage:       "verify all the masters agree on the owner of the slots.",
+	ArgsUsage:   `host:port`,
+	Description: `The verify-slots command checks no other master holds keys of the slots, the slots are not open and every master names the same owner, sending CLUSTER SETSLOT NODE again to the masters naming another owner. Open slots are left to fix.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "slots-range",
+			Usage: `Slots to verify, like 0-100,200, all the covered slots by default.`,
+		},
+		cli.IntFlag{
+			Name:  "retries",
+			Value: VerifySlotsDefaultRetries,
+			Usage: `Times CLUSTER SETSLOT NODE is sent again to the lagging masters.`,
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is "".`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "verify-slots")
+			logrus.Fatalf("Must provide \"host:port\" for verify-slots command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		rt := NewRedisTrib()
+		if err := rt.VerifySlotsClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+const (
+	VerifySlotsDefaultRetries = 3
+	VerifySlotsRetryDelay     = 500 * time.Millisecond
+)
+
+func (rt *RedisTrib) VerifySlotsClusterCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for verify-slots command")
+	}
+
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return err
+	}
+
+	// The owners of the slots as seen by the masters themselves.
+	owners := make(map[int]*ClusterNode)
+	for _, node := range rt.masters() {
+		for slot := range node.Slots() {
+			owners[slot] = node
+		}
+	}
+
+	if str := context.String("slots-range"); str != "" {
+		slots, err := ParseNumRange(str, ClusterHashSlots)
+		if err != nil {
+			logrus.Fatalf("Invalid slots range %q: %s", str, err.Error())
+		}
+		selected := make(map[int]*ClusterNode)
+		for _, slot := range slots {
+			if owners[slot] == nil {
+				logrus.Fatalf("*** Slot %d is not covered by any master.", slot)
+			}
+			selected[slot] = owners[slot]
+		}
+		owners = selected
+	}
+
+	logrus.Printf(">>> Verifying the owner of %d slots on %d masters", len(owners), len(rt.masters()))
+	problems, err := rt.VerifySlotsOwner(owners, context.Int("retries"))
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			logrus.Errorf("[ERR] %s", p)
+		}
+		logrus.Fatalf("*** %d problems with the owner of the slots.", len(problems))
+	}
+	logrus.Printf("[OK] All the masters agree on the owner of the %d slots.", len(owners))
+	return nil
+}
+
+// The slots configuration seen by a master.
+type SlotsView struct {
+	Owners map[int]string // slot to the name of its owner
+	Open   map[int]bool   // slots migrating or importing on the master
+}
+
+func (cn *ClusterNode) SlotsView() (*SlotsView, error) {
+	nodes, err := cn.ClusterNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	view := &SlotsView{Owners: make(map[int]string), Open: make(map[int]bool)}
+	for _, node := range nodes {
+		for slot := range node.slots {
+			view.Owners[slot] = node.name
+		}
+		if node.HasFlag("myself") {
+			for slot := range node.migrating {
+				view.Open[slot] = true
+			}
+			for slot := range node.importing {
+				view.Open[slot] = true
+			}
+		}
+	}
+	return view, nil
+}
+
+// Check the owners of the slots on every master: the other masters
+// have no key left in the slots, the slots are open on no master and
+// every master names the owner. Return the problems found, and the
+// masters naming another owner to send CLUSTER SETSLOT NODE again.
+func (rt *RedisTrib) CheckSlotsOwner(owners map[int]*ClusterNode) ([]string, map[int][]*ClusterNode, error) {
+	var problems []string
+	lagging := make(map[int][]*ClusterNode)
+	slots := make([]int, 0, len(owners))
+	for slot := range owners {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+
+	var masters []*ClusterNode
+	for _, node := range rt.Nodes() {
+		if !node.HasFlag("slave") {
+			masters = append(masters, node)
+		}
+	}
+
+	// Keys left behind in the slots of other masters.
+	for _, node := range masters {
+		var others []int
+		for _, slot := range slots {
+			if owners[slot] != node {
+				others = append(others, slot)
+			}
+		}
+		counts, err := node.CountKeysInSlots(others)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, slot := range others {
+			if counts[slot] > 0 {
+				problems = append(problems, fmt.Sprintf("%s still has %d keys of slot %d, served by %s",
+					node.String(), counts[slot], slot, owners[slot].String()))
+			}
+		}
+	}
+
+	for _, node := range masters {
+		view, err := node.SlotsView()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, slot := range slots {
+			owner := owners[slot]
+			if view.Open[slot] {
+				// Closing the slot here could lose the keys still to
+				// move, this is a job for fix.
+				problems = append(problems, fmt.Sprintf("slot %d is still open on %s, use fix", slot, node.String()))
+			} else if name := view.Owners[slot]; name != owner.Name() {
+				if name == "" {
+					name = "no master"
+				}
+				problems = append(problems, fmt.Sprintf("%s sees slot %d served by %s instead of %s",
+					node.String(), slot, name, owner.Name()))
+				lagging[slot] = append(lagging[slot], node)
+			}
+		}
+	}
+	return problems, lagging, nil
+}
+
+// Check the owners of the slots, sending CLUSTER SETSLOT NODE again to
+// the lagging masters up to 'retries' times. Return the problems left.
+func (rt *RedisTrib) VerifySlotsOwner(owners map[int]*ClusterNode, retries int) ([]string, error) {
+	for attempt := 0; ; attempt++ {
+		problems, lagging, err := rt.CheckSlotsOwner(owners)
+		if err != nil || len(lagging) == 0 || attempt >= retries {
+			return problems, err
+		}
+
+		for slot, nodes := range lagging {
+			for _, node := range nodes {
+				logrus.Printf("*** Setting slot %d node %s on %s again", slot, owners[slot].Name(), node.String())
+				if _, err := node.ClusterSetSlot(slot, "node", owners[slot].Name()); err != nil {
+					logrus.Errorf("Set slot %d node %s on %s: %s", slot, owners[slot].Name(), node.String(), err.Error())
+				}
+			}
+		}
+		time.Sleep(VerifySlotsRetryDelay)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/garyburd/redigo/redis"
+)
+
+// verify          host:port
+//                  --samples <arg>
+//                  --digest
+//                  --count <arg>
+//                  --recheck-delay <arg>
+var verifyCommand = cli.Command{
+	Name:        "verify",
+	Usage:       "verify the replicas hold the data of their master.",
+	ArgsUsage:   `host:port`,
+	Description: `The verify command compares the keys of every slot of the masters and their replicas, then the values of a sample of keys.`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "samples",
+			Value: VerifyDefaultSamples,
+			Usage: `Keys of every master whose values are compared, 0 to skip the values.`,
+		},
+		cli.BoolFlag{
+			Name:  "digest",
+			Usage: `Compare the values with DEBUG DIGEST-VALUE instead of DUMP, which ignores their encoding but needs the DEBUG command.`,
+		},
+		cli.IntFlag{
+			Name:  "count",
+			Value: ScanDefaultCount,
+			Usage: `COUNT hint given to every SCAN call.`,
+		},
+		cli.DurationFlag{
+			Name:  "recheck-delay",
+			Value: VerifyDefaultRecheckDelay,
+			Usage: `Time given to the replicas to catch up before checking the differences again.`,
+		},
+		cli.StringFlag{
+			Name:  "password, a",
+			Value: "",
+			Usage: `password, the default value is "".`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.NArg() != 1 {
+			fmt.Printf("Incorrect Usage.\n\n")
+			cli.ShowCommandHelp(context, "verify")
+			logrus.Fatalf("Must provide \"host:port\" for verify command!")
+		}
+
+		if context.String("password") != "" {
+			RedisPassword = context.String("password")
+		}
+
+		rt := NewRedisTrib()
+		if err := rt.VerifyClusterCmd(context); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+const (
+	VerifyDefaultSamples      = 1000
+	VerifyDefaultRecheckDelay = time.Second
+	VerifyMaxReported         = 20
+)
+
+type VerifyOpts struct {
+	Samples      int
+	Digest       bool
+	Count        int
+	RecheckDelay time.Duration
+}
+
+// The differences between a replica and its master.
+type ReplicaDivergence struct {
+	Node      *ClusterNode
+	Dbsize    int
+	Slots     []int // with a different number of keys
+	Counts    map[int]int
+	Keys      []string // with a different value
+	Missing   int
+	Different int
+}
+
+func (d *ReplicaDivergence) Diverges() bool {
+	return len(d.Slots) > 0 || len(d.Keys) > 0
+}
+
+func (rt *RedisTrib) VerifyClusterCmd(context *cli.Context) error {
+	var addr string
+
+	if addr = context.Args().Get(0); addr == "" {
+		return errors.New("please check host:port for verify command")
+	}
+
+	opts := &VerifyOpts{
+		Samples:      context.Int("samples"),
+		Digest:       context.Bool("digest"),
+		Count:        context.Int("count"),
+		RecheckDelay: context.Duration("recheck-delay"),
+	}
+
+	if err := rt.LoadClusterInfoFromNode(addr); err != nil {
+		return err
+	}
+
+	// Check cluster, only proceed if it looks sane.
+	rt.CheckCluster(false)
+
+	replicas, diverging := 0, 0
+	for _, master := range rt.masters() {
+		if len(master.ReplicasNodes()) == 0 {
+			logrus.Warnf("*** %s (%s) has no replica to verify.", master.String(), master.Name()[0:8])
+			continue
+		}
+
+		divergences, err := rt.VerifyShard(master, opts)
+		if err != nil {
+			return fmt.Errorf("verify of %s failed: %s", master.String(), err.Error())
+		}
+		for _, d := range divergences {
+			replicas++
+			if d.Diverges() {
+				diverging++
+			}
+		}
+	}
+
+	if diverging > 0 {
+		logrus.Fatalf("*** %d of %d replicas diverge from their master.", diverging, replicas)
+	}
+	logrus.Printf("[OK] All the %d replicas match their master.", replicas)
+	return nil
+}
+
+// Compare every replica of the master with it: the number of keys of
+// every slot, then the values of a sample of keys. The differences are
+// checked again after a delay, to leave out the writes not replicated
+// yet.
+func (rt *RedisTrib) VerifyShard(master *ClusterNode, opts *VerifyOpts) ([]*ReplicaDivergence, error) {
+	dbsize, err := master.Dbsize()
+	if err != nil {
+		return nil, err
+	}
+	slots := sortedSlots(master.Slots())
+	counts, err := master.CountKeysInSlots(slots)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Printf(">>> Verifying %s (%s): %d keys, %d replicas", master.String(), master.Name()[0:8], dbsize, len(master.ReplicasNodes()))
+
+	var sample []string
+	if opts.Samples > 0 {
+		err := master.ScanKeys(&ScanOpts{Count: opts.Count, Limit: opts.Samples}, func(keys []*ScannedKey) error {
+			for _, key := range keys {
+				sample = append(sample, key.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	digests, err := master.KeyDigests(sample, opts.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var divergences []*ReplicaDivergence
+	for _, r := range master.ReplicasNodes() {
+		node, err := NewReadOnlyNode(r.String())
+		if err != nil {
+			return nil, err
+		}
+		d, err := compareReplica(master, node, counts, sample, digests, opts)
+		node.Close()
+		if err != nil {
+			return nil, err
+		}
+		d.Node = r
+		d.Show(dbsize, len(sample))
+		divergences = append(divergences, d)
+	}
+	return divergences, nil
+}
+
+func compareReplica(master, replica *ClusterNode, counts map[int]int, sample []string, digests []string, opts *VerifyOpts) (*ReplicaDivergence, error) {
+	d := &ReplicaDivergence{}
+	var err error
+	if d.Dbsize, err = replica.Dbsize(); err != nil {
+		return nil, err
+	}
+
+	slots := sortedSlots(counts)
+	if d.Counts, err = replica.CountKeysInSlots(slots); err != nil {
+		return nil, err
+	}
+	for _, slot := range slots {
+		if d.Counts[slot] != counts[slot] {
+			d.Slots = append(d.Slots, slot)
+		}
+	}
+
+	replicaDigests, err := replica.KeyDigests(sample, opts.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var keys, keyDigests []string
+	for i, key := range sample {
+		// Keys deleted from the master since they were sampled.
+		if digests[i] != "" && replicaDigests[i] != digests[i] {
+			keys = append(keys, key)
+			keyDigests = append(keyDigests, digests[i])
+		}
+	}
+
+	if len(d.Slots) == 0 && len(keys) == 0 {
+		return d, nil
+	}
+
+	// Check the differences again, once the replica had the time to
+	// catch up.
+	time.Sleep(opts.RecheckDelay)
+	if len(d.Slots) > 0 {
+		again, err := master.CountKeysInSlots(d.Slots)
+		if err != nil {
+			return nil, err
+		}
+		replicaAgain, err := replica.CountKeysInSlots(d.Slots)
+		if err != nil {
+			return nil, err
+		}
+		diverging := d.Slots[:0]
+		for _, slot := range d.Slots {
+			if again[slot] != replicaAgain[slot] {
+				counts[slot], d.Counts[slot] = again[slot], replicaAgain[slot]
+				diverging = append(diverging, slot)
+			}
+		}
+		d.Slots = diverging
+	}
+
+	if len(keys) > 0 {
+		again, err := master.KeyDigests(keys, opts.Digest)
+		if err != nil {
+			return nil, err
+		}
+		replicaAgain, err := replica.KeyDigests(keys, opts.Digest)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range keys {
+			if again[i] == "" || again[i] == replicaAgain[i] {
+				continue
+			}
+			// Only report keys which didn't change on the master.
+			if again[i] != keyDigests[i] {
+				continue
+			}
+			d.Keys = append(d.Keys, key)
+			if replicaAgain[i] == "" {
+				d.Missing++
+			} else {
+				d.Different++
+			}
+		}
+	}
+	return d, nil
+}
+
+func (d *ReplicaDivergence) Show(dbsize int, sampled int) {
+	if !d.Diverges() {
+		logrus.Printf("[OK] %s (%s): %d keys, %d sampled values match.", d.Node.String(), d.Node.Name()[0:8], d.Dbsize, sampled)
+		return
+	}
+
+	logrus.Errorf("[ERR] %s (%s): %d keys, the master has %d.", d.Node.String(), d.Node.Name()[0:8], d.Dbsize, dbsize)
+	if len(d.Slots) > 0 {
+		logrus.Errorf("  %d slots have a different number of keys: %s", len(d.Slots), MergeNumArray2NumRange(d.Slots))
+		for i, slot := range d.Slots {
+			if i == VerifyMaxReported {
+				logrus.Printf("\t...")
+				break
+			}
+			logrus.Printf("\tslot %d: %d keys on the replica", slot, d.Counts[slot])
+		}
+	}
+	if len(d.Keys) > 0 {
+		logrus.Errorf("  %d of %d sampled keys diverge: %d missing, %d different", len(d.Keys), sampled, d.Missing, d.Different)
+		for i, key := range d.Keys {
+			if i == VerifyMaxReported {
+				logrus.Printf("\t...")
+				break
+			}
+			logrus.Printf("\t%s (slot %d)", key, Key2Slot(key))
+		}
+	}
+}
+
+// Return a digest of the value of every key, "" for the missing keys.
+// DUMP digests leave out the RDB version, to compare nodes running
+// different versions, but depend on the encoding of the values, unlike
+// DEBUG DIGEST-VALUE.
+func (cn *ClusterNode) KeyDigests(keys []string, debug bool) ([]string, error) {
+	if err := cn.Connect(false); err != nil {
+		return nil, err
+	}
+
+	digests := make([]string, 0, len(keys))
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > ScanDefaultCount {
+			batch = keys[:ScanDefaultCount]
+		}
+		keys = keys[len(batch):]
+
+		for _, key := range batch {
+			if debug {
+				cn.r.Send("DEBUG", "DIGEST-VALUE", key)
+			} else {
+				cn.r.Send("DUMP", key)
+			}
+		}
+		if err := cn.r.Flush(); err != nil {
+			return nil, err
+		}
+
+		var failed error
+		for range batch {
+			reply, err := cn.r.Receive()
+			digest, err := keyDigest(reply, err, debug)
+			if err != nil && failed == nil {
+				failed = err
+			}
+			digests = append(digests, digest)
+		}
+		if failed != nil {
+			return nil, failed
+		}
+	}
+	return digests, nil
+}
+
+func keyDigest(reply interface{}, err error, debug bool) (string, error) {
+	if debug {
+		values, err := redis.Strings(reply, err)
+		if err != nil {
+			return "", err
+		}
+		// Missing keys have a digest of zeros.
+		if len(values) == 0 || strings.Trim(values[0], "0") == "" {
+			return "", nil
+		}
+		return values[0], nil
+	}
+
+	payload, err := redis.Bytes(reply, err)
+	if err == redis.ErrNil {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if len(payload) < 11 {
+		return "", errors.New("DUMP payload too short")
+	}
+	sum := sha1.Sum(payload[:len(payload)-10])
+	return hex.EncodeToString(sum[:]), nil
+}